@@ -0,0 +1,48 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Group",type=string,JSONPath=`.status.dn`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterTeam is the Schema for the clusterteams API, an organisation wide Team
+// whose nested Teams are other ClusterTeams.
+type ClusterTeam struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TeamSpec   `json:"spec,omitempty"`
+	Status TeamStatus `json:"status,omitempty"`
+}
+
+func (t *ClusterTeam) GetSpec() *TeamSpec {
+	return &t.Spec
+}
+
+func (t *ClusterTeam) GetStatus() *TeamStatus {
+	return &t.Status
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterTeamList contains a list of ClusterTeam
+type ClusterTeamList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterTeam `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterTeam{}, &ClusterTeamList{})
+}
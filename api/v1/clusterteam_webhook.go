@@ -0,0 +1,62 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var clusterteamlog = logf.Log.WithName("clusterteam-resource")
+
+func (r *ClusterTeam) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-app-wellerman-bouchaud-org-v1-clusterteam,mutating=true,failurePolicy=fail,sideEffects=None,groups=app.wellerman.bouchaud.org,resources=clusterteams,verbs=create;update,versions=v1,name=mclusterteam.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &ClusterTeam{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *ClusterTeam) Default() {
+	clusterteamlog.Info("default", "name", r.Name)
+
+	defaultTeamSpec(&r.Spec)
+}
+
+//+kubebuilder:webhook:path=/validate-app-wellerman-bouchaud-org-v1-clusterteam,mutating=false,failurePolicy=fail,sideEffects=None,groups=app.wellerman.bouchaud.org,resources=clusterteams,verbs=create;update,versions=v1,name=vclusterteam.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &ClusterTeam{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterTeam) ValidateCreate() error {
+	clusterteamlog.Info("validate create", "name", r.Name)
+
+	return invalidTeam("ClusterTeam", r.Name, append(validateTeamSpec(&r.Spec, nil), validateReconcileAnnotation(r.Annotations)...))
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterTeam) ValidateUpdate(old runtime.Object) error {
+	clusterteamlog.Info("validate update", "name", r.Name)
+
+	// The finalizer of a ClusterTeam being deleted must be removable whatever
+	// its spec, even one created before the current rules.
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+
+	previous := old.(*ClusterTeam)
+	return invalidTeam("ClusterTeam", r.Name, append(validateTeamSpec(&r.Spec, &previous.Spec), validateReconcileAnnotationUpdate(r.Annotations, previous.Annotations)...))
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterTeam) ValidateDelete() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DirectoryTLS configures how the connection to the directory is secured.
+type DirectoryTLS struct {
+	// StartTLS upgrades a ldap:// connection before binding.
+	// +kubebuilder:validation:Optional
+	StartTLS bool `json:"startTLS,omitempty"`
+
+	// CASecretRef selects a PEM bundle used to verify the server certificate.
+	// +kubebuilder:validation:Optional
+	CASecretRef *SecretKeyReference `json:"caSecretRef,omitempty"`
+
+	// ClientCertificateSecretRef selects a kubernetes.io/tls Secret holding the
+	// client certificate presented to the server.
+	// +kubebuilder:validation:Optional
+	ClientCertificateSecretRef *SecretReference `json:"clientCertificateSecretRef,omitempty"`
+
+	// SASLExternal binds with the client certificate instead of the bind DN.
+	// +kubebuilder:validation:Optional
+	SASLExternal bool `json:"saslExternal,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	ServerName string `json:"serverName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// DirectorySchema describes how groups are stored in the directory.
+type DirectorySchema struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=groupOfUniqueNames
+	GroupObjectClass string `json:"groupObjectClass,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=uniqueMember
+	MemberAttribute string `json:"memberAttribute,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=cn
+	GroupNameProperty string `json:"groupNameProperty,omitempty"`
+}
+
+// DirectorySpec defines the desired state of Directory
+type DirectorySpec struct {
+	// +kubebuilder:validation:Required
+	URL string `json:"url"`
+
+	// +kubebuilder:validation:Optional
+	BindDN string `json:"bindDN,omitempty"`
+
+	// BindSecretRef selects the password of BindDN.
+	// +kubebuilder:validation:Optional
+	BindSecretRef *SecretKeyReference `json:"bindSecretRef,omitempty"`
+
+	// +kubebuilder:validation:Required
+	GroupSearchBase string `json:"groupSearchBase"`
+
+	// GroupDNTemplate overrides the group DN template of the operator.
+	// +kubebuilder:validation:Optional
+	GroupDNTemplate string `json:"groupDNTemplate,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	UserSearchBase string `json:"userSearchBase,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=base;single;sub
+	// +kubebuilder:default:=sub
+	UserSearchScope string `json:"userSearchScope,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="(&(objectClass=inetOrgPerson)(uid=%s))"
+	UserSearchFilter string `json:"userSearchFilter,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="(&(objectClass=inetOrgPerson)(mail=%s))"
+	UserEmailFilter string `json:"userEmailFilter,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:={}
+	Schema DirectorySchema `json:"schema,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	TLS DirectoryTLS `json:"tls,omitempty"`
+
+	// AllowedNamespaces lists the namespaces whose Teams may use the
+	// Directory, and so the Secrets it reads. ClusterTeams always may. Teams
+	// of any namespace may when it is empty.
+	// +kubebuilder:validation:Optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+}
+
+// DirectoryStatus defines the observed state of Directory
+type DirectoryStatus struct {
+	// Conditions holds Ready, which tells whether a client could be built
+	// from the Directory and the Secrets it reads.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// Directory is the Schema for the directories API, an LDAP directory Teams
+// can reference to have their group managed in.
+type Directory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DirectorySpec   `json:"spec,omitempty"`
+	Status DirectoryStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DirectoryList contains a list of Directory
+type DirectoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Directory `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Directory{}, &DirectoryList{})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GitlabInstanceTLS configures how the certificate of the instance is verified.
+type GitlabInstanceTLS struct {
+	// CASecretRef selects a PEM bundle used to verify the instance certificate.
+	// +kubebuilder:validation:Optional
+	CASecretRef *SecretKeyReference `json:"caSecretRef,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// GitlabInstanceSpec defines the desired state of GitlabInstance
+type GitlabInstanceSpec struct {
+	// +kubebuilder:validation:Required
+	URL string `json:"url"`
+
+	// TokenSecretRef selects the token used to authenticate with.
+	// +kubebuilder:validation:Required
+	TokenSecretRef SecretKeyReference `json:"tokenSecretRef"`
+
+	// +kubebuilder:validation:Optional
+	TLS GitlabInstanceTLS `json:"tls,omitempty"`
+
+	// DefaultVisibility is the visibility of the projects and groups created
+	// on the instance.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=private;internal;public
+	// +kubebuilder:default:=private
+	DefaultVisibility string `json:"defaultVisibility,omitempty"`
+
+	// AllowedNamespaces lists the namespaces whose Projects may have paths on
+	// the instance, and so use the Secrets it reads. Projects of any namespace
+	// may when it is empty.
+	// +kubebuilder:validation:Optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+}
+
+// GitlabInstanceStatus defines the observed state of GitlabInstance
+type GitlabInstanceStatus struct {
+	// Conditions holds Ready, which tells whether a client could be built
+	// from the GitlabInstance and the Secrets it reads.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// GitlabInstance is the Schema for the gitlabinstances API, a GitLab server
+// Project paths can select to be managed on.
+type GitlabInstance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GitlabInstanceSpec   `json:"spec,omitempty"`
+	Status GitlabInstanceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// GitlabInstanceList contains a list of GitlabInstance
+type GitlabInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GitlabInstance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&GitlabInstance{}, &GitlabInstanceList{})
+}
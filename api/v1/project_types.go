@@ -9,12 +9,18 @@ import (
 )
 
 type ProjectPath struct {
-	// +kubebuilder:validation:Required
-	Name string `json:"name"`
+	// Name defaults to the last element of Path.
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
 
 	// +kubebuilder:validation:Required
 	Path string `json:"path"`
 
+	// Instance is the name of the GitlabInstance the project is managed on.
+	// The instance configured on the operator is used when empty.
+	// +kubebuilder:validation:Optional
+	Instance string `json:"instance,omitempty"`
+
 	// +kubebuilder:validation:Optional
 	Description string `json:"description,omitempty"`
 
@@ -22,9 +28,53 @@ type ProjectPath struct {
 	// +kubebuilder:default:=false
 	External bool `json:"external,omitempty"`
 
+	// ArchiveOnDelete is superseded by DeletionPolicy, it only sets its
+	// default.
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default:=true
 	ArchiveOnDelete bool `json:"archive-on-delete,omitempty"`
+
+	// DeletionPolicy tells what becomes of the GitLab project when the path
+	// or the Project is removed. It defaults to Archive, or to Delete when
+	// archive-on-delete is false.
+	// +kubebuilder:validation:Optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// DeletionGracePeriod is how long the project is kept archived before
+	// being deleted with the DeleteAfter policy.
+	// +kubebuilder:validation:Optional
+	DeletionGracePeriod *metav1.Duration `json:"deletionGracePeriod,omitempty"`
+}
+
+// DeletionPolicy tells what to do with a GitLab project once it is no longer
+// declared. Projects to be deleted are archived for a grace period first,
+// recreating their path meanwhile restores them.
+// +kubebuilder:validation:Enum=Retain;Archive;Delete;DeleteAfter
+type DeletionPolicy string
+
+const (
+	// DeletionRetain leaves the project as is.
+	DeletionRetain DeletionPolicy = "Retain"
+	// DeletionArchive archives the project.
+	DeletionArchive DeletionPolicy = "Archive"
+	// DeletionDelete deletes the project after the grace period of the
+	// operator.
+	DeletionDelete DeletionPolicy = "Delete"
+	// DeletionDeleteAfter deletes the project after its DeletionGracePeriod.
+	DeletionDeleteAfter DeletionPolicy = "DeleteAfter"
+)
+
+// GetDeletionPolicy returns the DeletionPolicy of the path, derived from
+// ArchiveOnDelete when not set.
+func (p ProjectPath) GetDeletionPolicy() DeletionPolicy {
+	switch {
+	case p.DeletionPolicy != "":
+		return p.DeletionPolicy
+	case p.ArchiveOnDelete:
+		return DeletionArchive
+	default:
+		return DeletionDelete
+	}
 }
 
 // ProjectSpec defines the desired state of Project
@@ -32,13 +82,53 @@ type ProjectSpec struct {
 	Paths []ProjectPath `json:"paths"`
 }
 
+// PathState tells where a path stands on GitLab.
+type PathState string
+
+const (
+	PathSynced   PathState = "Synced"
+	PathFailed   PathState = "Failed"
+	PathExternal PathState = "External"
+	// PathPlanned is a path that differs from the spec, with changes left
+	// unwritten by the dry-run mode.
+	PathPlanned PathState = "Planned"
+	// PathDrifted is a path that was synced, then changed outside of
+	// wellerman, with the changes left uncorrected by the report drift
+	// policy.
+	PathDrifted PathState = "Drifted"
+)
+
+// ProjectPathStatus is the observed state of a path of a Project.
+type ProjectPathStatus struct {
+	Path     string    `json:"path"`
+	Instance string    `json:"instance,omitempty"`
+	State    PathState `json:"state"`
+
+	// ProjectID is the GitLab ID of the project.
+	ProjectID int    `json:"projectID,omitempty"`
+	WebURL    string `json:"webURL,omitempty"`
+
+	// Message explains why the path failed to sync, or lists the changes
+	// planned in dry-run.
+	Message string `json:"message,omitempty"`
+}
+
 // ProjectStatus defines the observed state of Project
 type ProjectStatus struct {
+	// ObservedGeneration is the generation of the spec last reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	Conditions []metav1.Condition `json:"conditions"`
+
+	Paths []ProjectPathStatus `json:"paths,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Message",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Project is the Schema for the projects API
 type Project struct {
@@ -0,0 +1,183 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var projectlog = logf.Log.WithName("project-resource")
+
+// gitlabPathSegment is what GitLab accepts as a group or project path.
+var gitlabPathSegment = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+
+// gitlabReservedNames cannot be used as top level group paths, they collide
+// with GitLab routes.
+var gitlabReservedNames = map[string]bool{
+	"-": true, ".well-known": true, "404.html": true, "422.html": true, "500.html": true,
+	"502.html": true, "503.html": true, "admin": true, "api": true, "apple-touch-icon.png": true,
+	"assets": true, "dashboard": true, "deploy.html": true, "explore": true, "favicon.ico": true,
+	"favicon.png": true, "files": true, "groups": true, "health_check": true, "help": true,
+	"import": true, "jwt": true, "login": true, "oauth": true, "profile": true, "projects": true,
+	"public": true, "robots.txt": true, "s": true, "search": true, "sitemap": true,
+	"sitemap.xml": true, "sitemap.xml.gz": true, "slash-command-logo.png": true, "snippets": true,
+	"unsubscribes": true, "uploads": true, "users": true, "v2": true,
+}
+
+func (r *Project) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-app-wellerman-bouchaud-org-v1-project,mutating=true,failurePolicy=fail,sideEffects=None,groups=app.wellerman.bouchaud.org,resources=projects,verbs=create;update,versions=v1,name=mproject.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Project{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Project) Default() {
+	projectlog.Info("default", "name", r.Name)
+
+	for i := range r.Spec.Paths {
+		if r.Spec.Paths[i].Name == "" {
+			r.Spec.Paths[i].Name = path.Base(r.Spec.Paths[i].Path)
+		}
+		r.Spec.Paths[i].DeletionPolicy = r.Spec.Paths[i].GetDeletionPolicy()
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-app-wellerman-bouchaud-org-v1-project,mutating=false,failurePolicy=fail,sideEffects=None,groups=app.wellerman.bouchaud.org,resources=projects,verbs=create;update,versions=v1,name=vproject.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Project{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Project) ValidateCreate() error {
+	projectlog.Info("validate create", "name", r.Name)
+
+	return r.validate(nil)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Project) ValidateUpdate(old runtime.Object) error {
+	projectlog.Info("validate update", "name", r.Name)
+
+	// The finalizer of a Project being deleted must be removable whatever its
+	// spec, even one created before the current rules.
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+
+	return r.validate(old.(*Project))
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Project) ValidateDelete() error {
+	return nil
+}
+
+// validate checks the paths of the Project. On update, the syntax and the
+// deletion policy are only checked on the paths that are new or changed, so
+// that a Project created before a rule existed can still be updated, by its
+// controller in the first place. Duplicates are refused in any case.
+func (r *Project) validate(old *Project) error {
+	var errs field.ErrorList
+
+	previous := map[string]ProjectPath{}
+	if old != nil {
+		errs = append(errs, validateReconcileAnnotationUpdate(r.Annotations, old.Annotations)...)
+
+		for _, projectPath := range old.Spec.Paths {
+			previous[strings.ToLower(projectPath.Path)] = projectPath
+		}
+	} else {
+		errs = append(errs, validateReconcileAnnotation(r.Annotations)...)
+	}
+
+	count := map[string]int{}
+	for _, projectPath := range r.Spec.Paths {
+		count[strings.ToLower(projectPath.Instance+":"+projectPath.Path)]++
+	}
+
+	for i, projectPath := range r.Spec.Paths {
+		fldPath := field.NewPath("spec", "paths").Index(i)
+
+		// A path is never managed twice, whenever it was added.
+		if count[strings.ToLower(projectPath.Instance+":"+projectPath.Path)] > 1 {
+			errs = append(errs, field.Duplicate(fldPath.Child("path"), projectPath.Path))
+		}
+
+		if old != nil && containsPath(old.Spec.Paths, projectPath) {
+			continue
+		}
+
+		errs = append(errs, validateGitlabPath(fldPath.Child("path"), projectPath.Path)...)
+
+		grace := projectPath.DeletionGracePeriod
+		switch {
+		case projectPath.DeletionPolicy == DeletionDeleteAfter && (grace == nil || grace.Duration <= 0):
+			errs = append(errs, field.Required(fldPath.Child("deletionGracePeriod"), "a positive grace period is required by the DeleteAfter policy"))
+		case projectPath.DeletionPolicy != DeletionDeleteAfter && grace != nil:
+			errs = append(errs, field.Forbidden(fldPath.Child("deletionGracePeriod"), "only used by the DeleteAfter policy"))
+		}
+
+		if p, ok := previous[strings.ToLower(projectPath.Path)]; ok && p.Instance != projectPath.Instance {
+			errs = append(errs, field.Forbidden(fldPath.Child("instance"), fmt.Sprintf("cannot move %s from instance %q, remove the path and add it back instead", projectPath.Path, p.Instance)))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind("Project").GroupKind(), r.Name, errs)
+}
+
+// containsPath tells whether paths holds projectPath, unchanged.
+func containsPath(paths []ProjectPath, projectPath ProjectPath) bool {
+	for _, p := range paths {
+		if equality.Semantic.DeepEqual(p, projectPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateGitlabPath(fldPath *field.Path, p string) field.ErrorList {
+	var errs field.ErrorList
+
+	if strings.HasPrefix(p, "/") || strings.HasSuffix(p, "/") {
+		return append(errs, field.Invalid(fldPath, p, "must not start or end with a slash"))
+	}
+
+	segments := strings.Split(p, "/")
+	if len(segments) < 2 {
+		return append(errs, field.Invalid(fldPath, p, "must be made of a group path and a project path"))
+	}
+
+	for i, segment := range segments {
+		switch {
+		case !gitlabPathSegment.MatchString(segment):
+			errs = append(errs, field.Invalid(fldPath, p, fmt.Sprintf("%q must only contain letters, digits, '_', '-' and '.', and not start with '-' or '.'", segment)))
+		case strings.HasSuffix(segment, ".git") || strings.HasSuffix(segment, ".atom"):
+			errs = append(errs, field.Invalid(fldPath, p, fmt.Sprintf("%q must not end with '.git' or '.atom'", segment)))
+		case i == 0 && gitlabReservedNames[strings.ToLower(segment)]:
+			errs = append(errs, field.Invalid(fldPath, p, fmt.Sprintf("%q is reserved by GitLab", segment)))
+		}
+	}
+
+	return errs
+}
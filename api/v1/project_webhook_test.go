@@ -0,0 +1,146 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Project webhook", func() {
+	BeforeEach(requireEnvtest)
+
+	newProject := func(name string, paths ...ProjectPath) *Project {
+		return &Project{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       ProjectSpec{Paths: paths},
+		}
+	}
+
+	It("defaults the name of a path to its last element", func() {
+		project := newProject("defaulted", ProjectPath{Path: "infra/wellerman"})
+		Expect(k8sClient.Create(ctx, project)).To(Succeed())
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(project), project)).To(Succeed())
+		Expect(project.Spec.Paths[0].Name).To(Equal("wellerman"))
+	})
+
+	DescribeTable("rejects invalid paths",
+		func(p string) {
+			err := k8sClient.Create(ctx, newProject("invalid", ProjectPath{Path: p}))
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+		},
+		Entry("with a leading slash", "/infra/wellerman"),
+		Entry("with a space", "infra/well erman"),
+		Entry("without a group", "wellerman"),
+		Entry("with a reserved group", "api/wellerman"),
+		Entry("ending with .git", "infra/wellerman.git"),
+	)
+
+	It("defaults the deletion policy from archive-on-delete", func() {
+		project := newProject("deletion-policy",
+			ProjectPath{Path: "infra/archived", ArchiveOnDelete: true},
+			ProjectPath{Path: "infra/deleted", DeletionPolicy: DeletionDelete},
+		)
+		Expect(k8sClient.Create(ctx, project)).To(Succeed())
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(project), project)).To(Succeed())
+		Expect(project.Spec.Paths[0].DeletionPolicy).To(Equal(DeletionArchive))
+		Expect(project.Spec.Paths[1].DeletionPolicy).To(Equal(DeletionDelete))
+	})
+
+	It("requires a grace period with the DeleteAfter policy", func() {
+		err := k8sClient.Create(ctx, newProject("delete-after", ProjectPath{Path: "infra/delete-after", DeletionPolicy: DeletionDeleteAfter}))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+	})
+
+	It("rejects duplicate paths", func() {
+		err := k8sClient.Create(ctx, newProject("duplicate",
+			ProjectPath{Path: "infra/wellerman"},
+			ProjectPath{Path: "infra/Wellerman"},
+		))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+	})
+
+	It("rejects moving a path to another instance", func() {
+		project := newProject("moved", ProjectPath{Path: "infra/moved"})
+		Expect(k8sClient.Create(ctx, project)).To(Succeed())
+
+		project.Spec.Paths[0].Instance = "gitlab-com"
+		err := k8sClient.Update(ctx, project)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+	})
+
+	It("rejects an unknown reconcile mode", func() {
+		project := newProject("unknown-mode", ProjectPath{Path: "infra/unknown-mode"})
+		project.Annotations = map[string]string{ReconcileAnnotation: "observe"}
+
+		err := k8sClient.Create(ctx, project)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+	})
+	It("lets a Project created before the current rules be deleted", func() {
+		project := newProject("legacy", ProjectPath{Path: "wellerman"})
+		project.Finalizers = []string{"app.wellerman.bouchaud.org/test"}
+		withoutValidation(func() {
+			Eventually(func() error { return k8sClient.Create(ctx, project) }).Should(Succeed())
+		})
+
+		By("updating it without touching its paths")
+		Eventually(func() error {
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(project), project); err != nil {
+				return err
+			}
+			project.Labels = map[string]string{"team": "infra"}
+			return k8sClient.Update(ctx, project)
+		}).Should(Succeed())
+
+		By("removing its finalizer once deleted")
+		Expect(k8sClient.Delete(ctx, project)).To(Succeed())
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(project), project)).To(Succeed())
+		project.Finalizers = nil
+		Expect(k8sClient.Update(ctx, project)).To(Succeed())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(project), project))).To(BeTrue())
+	})
+})
+
+var _ = Describe("Project updates", func() {
+	It("only validates the paths that changed", func() {
+		old := &Project{Spec: ProjectSpec{Paths: []ProjectPath{{Path: "wellerman"}}}}
+
+		updated := old.DeepCopy()
+		Expect(updated.ValidateUpdate(old)).To(Succeed())
+
+		updated.Spec.Paths = append(updated.Spec.Paths, ProjectPath{Path: "api/wellerman"})
+		Expect(apierrors.IsInvalid(updated.ValidateUpdate(old))).To(BeTrue())
+
+		updated.Spec.Paths[1].Path = "infra/wellerman"
+		Expect(updated.ValidateUpdate(old)).To(Succeed())
+	})
+
+	It("refuses to duplicate an unchanged path", func() {
+		old := &Project{Spec: ProjectSpec{Paths: []ProjectPath{{Path: "infra/wellerman"}}}}
+
+		updated := old.DeepCopy()
+		updated.Spec.Paths = append(updated.Spec.Paths, old.Spec.Paths[0])
+		Expect(apierrors.IsInvalid(updated.ValidateUpdate(old))).To(BeTrue())
+	})
+
+	It("does not validate Projects being deleted", func() {
+		old := &Project{Spec: ProjectSpec{Paths: []ProjectPath{{Path: "wellerman"}}}}
+		old.Annotations = map[string]string{ReconcileAnnotation: "observe"}
+
+		updated := old.DeepCopy()
+		updated.Spec.Paths[0].Instance = "gitlab-com"
+		Expect(updated.ValidateUpdate(old)).NotTo(Succeed())
+
+		now := metav1.Now()
+		updated.DeletionTimestamp = &now
+		Expect(updated.ValidateUpdate(old)).To(Succeed())
+	})
+})
@@ -0,0 +1,68 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ReconcileAnnotation sets the mode a Team, ClusterTeam or Project is
+// reconciled in, taking precedence over the mode of the operator.
+const ReconcileAnnotation = "wellerman.bouchaud.org/reconcile"
+
+// ReconcileMode tells whether wellerman writes to GitLab and the directory.
+type ReconcileMode string
+
+const (
+	// ReconcileEnforce writes the changes needed for the backend to match
+	// the spec.
+	ReconcileEnforce ReconcileMode = "enforce"
+	// ReconcileDryRun computes the changes and reports them in status and
+	// events without writing them. A resource deleted in dry-run keeps its
+	// finalizer, and its backend, until it leaves dry-run.
+	ReconcileDryRun ReconcileMode = "dry-run"
+	// ReconcilePaused leaves the resource and its backend untouched, deletion
+	// included.
+	ReconcilePaused ReconcileMode = "paused"
+)
+
+// IsValid tells whether m is one of the known modes.
+func (m ReconcileMode) IsValid() bool {
+	switch m {
+	case ReconcileEnforce, ReconcileDryRun, ReconcilePaused:
+		return true
+	}
+	return false
+}
+
+func validateReconcileAnnotation(annotations map[string]string) field.ErrorList {
+	mode, ok := annotations[ReconcileAnnotation]
+	if !ok || ReconcileMode(mode).IsValid() {
+		return nil
+	}
+
+	fldPath := field.NewPath("metadata", "annotations").Key(ReconcileAnnotation)
+	return field.ErrorList{field.NotSupported(fldPath, mode, []string{string(ReconcileEnforce), string(ReconcileDryRun), string(ReconcilePaused)})}
+}
+
+// validateReconcileAnnotationUpdate only validates the annotation when it
+// changed, an object must stay updatable whatever it was created with.
+func validateReconcileAnnotationUpdate(annotations, old map[string]string) field.ErrorList {
+	if mode, ok := annotations[ReconcileAnnotation]; ok {
+		if previous, ok := old[ReconcileAnnotation]; ok && previous == mode {
+			return nil
+		}
+	}
+	return validateReconcileAnnotation(annotations)
+}
+
+// ModeOf returns the mode of the given annotations, mode when they do not set
+// any valid one.
+func ModeOf(annotations map[string]string, mode ReconcileMode) ReconcileMode {
+	if m := ReconcileMode(annotations[ReconcileAnnotation]); m.IsValid() {
+		return m
+	}
+	return mode
+}
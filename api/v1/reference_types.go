@@ -0,0 +1,26 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+// SecretKeyReference selects a key of a Secret.
+type SecretKeyReference struct {
+	// +kubebuilder:validation:Required
+	Namespace string `json:"namespace"`
+
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Required
+	Key string `json:"key"`
+}
+
+// SecretReference selects a Secret.
+type SecretReference struct {
+	// +kubebuilder:validation:Required
+	Namespace string `json:"namespace"`
+
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+}
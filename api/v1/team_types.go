@@ -6,25 +6,156 @@ package v1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TeamMember designates a member of a Team by exactly one of its identifiers.
+// +kubebuilder:validation:MinProperties:=1
+// +kubebuilder:validation:MaxProperties:=1
+type TeamMember struct {
+	// User is the login of the member, looked up in the user search base.
+	// +kubebuilder:validation:Optional
+	User string `json:"user,omitempty"`
+
+	// Email is the mail address of the member, looked up in the user search base.
+	// +kubebuilder:validation:Optional
+	Email string `json:"email,omitempty"`
+
+	// Team is the name of another Team of the same namespace whose members are included,
+	// resolved against the Directory of that Team.
+	// +kubebuilder:validation:Optional
+	Team string `json:"team,omitempty"`
+
+	// DN is the distinguished name of the member.
+	// +kubebuilder:validation:Optional
+	DN string `json:"dn,omitempty"`
+}
+
+func (m TeamMember) String() string {
+	switch {
+	case m.User != "":
+		return "user: " + m.User
+	case m.Email != "":
+		return "email: " + m.Email
+	case m.Team != "":
+		return "team: " + m.Team
+	default:
+		return "dn: " + m.DN
+	}
+}
+
+// MembershipPolicy tells how wellerman treats members of the group it did not declare.
+// +kubebuilder:validation:Enum=Exclusive;Additive
+type MembershipPolicy string
+
+const (
+	// MembershipExclusive removes every member that is not declared by the Team.
+	MembershipExclusive MembershipPolicy = "Exclusive"
+	// MembershipAdditive only ensures the declared members, members added by
+	// other tools are left in place.
+	MembershipAdditive MembershipPolicy = "Additive"
+)
+
+// ConfirmDeletionAnnotation, set to "true", confirms the deletion of a Team,
+// or ClusterTeam, whose group still has members when the operator requires
+// it. It also lets a Team whose Directory, or a Project whose GitlabInstance,
+// can no longer be used be deleted, leaving its group or projects in place.
+const ConfirmDeletionAnnotation = "wellerman.bouchaud.org/confirm-deletion"
+
+// AdoptionPolicy tells what to do with an existing group that was not created by wellerman.
+// +kubebuilder:validation:Enum=Adopt;Observe;Refuse
+type AdoptionPolicy string
+
+const (
+	// AdoptionAdopt marks the group as managed by wellerman and manages it.
+	AdoptionAdopt AdoptionPolicy = "Adopt"
+	// AdoptionObserve leaves the group untouched.
+	AdoptionObserve AdoptionPolicy = "Observe"
+	// AdoptionRefuse leaves the group untouched and reports a conflict.
+	AdoptionRefuse AdoptionPolicy = "Refuse"
 )
 
 // TeamSpec defines the desired state of Team
 type TeamSpec struct {
 	Comment string `json:"comment,omitempty"`
 
-	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:MinItems:=1
-	Subjects []string `json:"subjects"`
+	// GroupName is the name of the group in the directory. It defaults to a
+	// name derived from the Team according to the naming strategy of the operator.
+	// +kubebuilder:validation:Optional
+	GroupName string `json:"groupName,omitempty"`
+
+	// Directory is the name of the Directory the group is managed in. The
+	// directory configured on the operator is used when empty.
+	// +kubebuilder:validation:Optional
+	Directory string `json:"directory,omitempty"`
+
+	// Subjects are the distinguished names of the members. At least one of
+	// Subjects, Members and MemberFilter must be set.
+	// +kubebuilder:validation:Optional
+	Subjects []string `json:"subjects,omitempty"`
+
+	// Members are resolved to distinguished names and merged with Subjects.
+	// +kubebuilder:validation:Optional
+	Members []TeamMember `json:"members,omitempty"`
+
+	// MemberFilter is an LDAP filter evaluated against the user search base,
+	// every matching entry is a member of the Team.
+	// +kubebuilder:validation:Optional
+	MemberFilter string `json:"memberFilter,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=Exclusive
+	MembershipPolicy MembershipPolicy `json:"membershipPolicy,omitempty"`
+
+	// AdoptionPolicy tells what to do when the group exists but was not
+	// created by wellerman. Groups are only taken over when set to Adopt, which
+	// is also needed once for the groups created before wellerman marked them.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=Observe
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+}
+
+// MemberState tells whether a member of a Team could be resolved.
+type MemberState string
+
+const (
+	MemberResolved MemberState = "Resolved"
+	MemberNotFound MemberState = "NotFound"
+	MemberCycle    MemberState = "Cycle"
+)
+
+// TeamMemberStatus is the outcome of the resolution of a member.
+type TeamMemberStatus struct {
+	// Member is the member as declared, such as "user:jdoe".
+	Member string      `json:"member"`
+	State  MemberState `json:"state"`
+
+	// DNs are the distinguished names the member resolved to, omitted for
+	// nested Teams.
+	DNs []string `json:"dns,omitempty"`
 }
 
 // TeamStatus defines the observed state of Team
 type TeamStatus struct {
+	// ObservedGeneration is the generation of the spec last reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	Conditions        []metav1.Condition `json:"conditions"`
 	DistinguishedName string             `json:"dn,omitempty"`
+
+	// Members are the resolved distinguished names of the members of the group.
+	Members []string `json:"members,omitempty"`
+
+	// MemberStatuses tell how each declared member was resolved.
+	MemberStatuses []TeamMemberStatus `json:"memberStatuses,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Group",type=string,JSONPath=`.status.dn`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Team is the Schema for the teams API
 type Team struct {
@@ -35,6 +166,22 @@ type Team struct {
 	Status TeamStatus `json:"status,omitempty"`
 }
 
+func (t *Team) GetSpec() *TeamSpec {
+	return &t.Spec
+}
+
+func (t *Team) GetStatus() *TeamStatus {
+	return &t.Status
+}
+
+// TeamObject is implemented by the namespaced Team and the cluster scoped ClusterTeam.
+// +kubebuilder:object:generate=false
+type TeamObject interface {
+	client.Object
+	GetSpec() *TeamSpec
+	GetStatus() *TeamStatus
+}
+
 //+kubebuilder:object:root=true
 
 // TeamList contains a list of Team
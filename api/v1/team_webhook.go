@@ -0,0 +1,180 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+import (
+	"errors"
+	"strings"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/vbouchaud/wellerman/internal/dn"
+)
+
+// log is for logging in this package.
+var teamlog = logf.Log.WithName("team-resource")
+
+func (r *Team) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-app-wellerman-bouchaud-org-v1-team,mutating=true,failurePolicy=fail,sideEffects=None,groups=app.wellerman.bouchaud.org,resources=teams,verbs=create;update,versions=v1,name=mteam.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Team{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Team) Default() {
+	teamlog.Info("default", "name", r.Name)
+
+	defaultTeamSpec(&r.Spec)
+}
+
+//+kubebuilder:webhook:path=/validate-app-wellerman-bouchaud-org-v1-team,mutating=false,failurePolicy=fail,sideEffects=None,groups=app.wellerman.bouchaud.org,resources=teams,verbs=create;update,versions=v1,name=vteam.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Team{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Team) ValidateCreate() error {
+	teamlog.Info("validate create", "name", r.Name)
+
+	return invalidTeam("Team", r.Name, append(validateTeamSpec(&r.Spec, nil), validateReconcileAnnotation(r.Annotations)...))
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Team) ValidateUpdate(old runtime.Object) error {
+	teamlog.Info("validate update", "name", r.Name)
+
+	// The finalizer of a Team being deleted must be removable whatever its
+	// spec, even one created before the current rules.
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+
+	previous := old.(*Team)
+	return invalidTeam("Team", r.Name, append(validateTeamSpec(&r.Spec, &previous.Spec), validateReconcileAnnotationUpdate(r.Annotations, previous.Annotations)...))
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Team) ValidateDelete() error {
+	return nil
+}
+
+// defaultTeamSpec normalises the DNs of a Team, or ClusterTeam, so that the
+// same person is not listed twice under two spellings.
+func defaultTeamSpec(spec *TeamSpec) {
+	for i, subject := range spec.Subjects {
+		if dn, err := normalizeDN(subject); err == nil {
+			spec.Subjects[i] = dn
+		}
+	}
+
+	for i, member := range spec.Members {
+		if member.DN == "" {
+			continue
+		}
+		if dn, err := normalizeDN(member.DN); err == nil {
+			spec.Members[i].DN = dn
+		}
+	}
+}
+
+// validateTeamSpec checks spec. On update, only the subjects, members and
+// filter that are new or changed are checked, so that a Team created before a
+// rule existed can still be updated, by its controller in the first place.
+func validateTeamSpec(spec, old *TeamSpec) field.ErrorList {
+	var errs field.ErrorList
+
+	specPath := field.NewPath("spec")
+
+	if !hasMembers(spec) && (old == nil || hasMembers(old)) {
+		errs = append(errs, field.Required(specPath, "at least one of subjects, members and memberFilter must be set, a group cannot be left without any member"))
+	}
+
+	previous := map[string]bool{}
+	if old != nil {
+		for _, subject := range old.Subjects {
+			previous[subject] = true
+		}
+		for _, member := range old.Members {
+			previous[member.DN] = true
+		}
+	}
+
+	for i, subject := range spec.Subjects {
+		if previous[subject] {
+			continue
+		}
+		if _, err := normalizeDN(subject); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("subjects").Index(i), subject, err.Error()))
+		}
+	}
+
+	for i, member := range spec.Members {
+		if member.DN == "" || previous[member.DN] {
+			continue
+		}
+		if _, err := normalizeDN(member.DN); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("members").Index(i).Child("dn"), member.DN, err.Error()))
+		}
+	}
+
+	if spec.MemberFilter != "" && (old == nil || old.MemberFilter != spec.MemberFilter) {
+		if _, err := ldapv3.CompileFilter(spec.MemberFilter); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("memberFilter"), spec.MemberFilter, err.Error()))
+		}
+	}
+
+	if old != nil && old.Directory != spec.Directory {
+		errs = append(errs, field.Forbidden(specPath.Child("directory"), "cannot move a group to another Directory, recreate the Team instead"))
+	}
+
+	return errs
+}
+
+// hasMembers tells whether spec sets any of subjects, members and
+// memberFilter.
+func hasMembers(spec *TeamSpec) bool {
+	return len(spec.Subjects) > 0 || len(spec.Members) > 0 || spec.MemberFilter != ""
+}
+
+func invalidTeam(kind, name string, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind(kind).GroupKind(), name, errs)
+}
+
+// normalizeDN parses value and writes it back with lower case attribute types
+// and no superfluous spaces. Unlike ldapv3.DN.String, non ASCII values are
+// left as is.
+func normalizeDN(value string) (string, error) {
+	parsed, err := ldapv3.ParseDN(value)
+	if err != nil {
+		return "", err
+	}
+	if len(parsed.RDNs) == 0 {
+		return "", errors.New("DN must not be empty")
+	}
+
+	rdns := make([]string, len(parsed.RDNs))
+	for i, rdn := range parsed.RDNs {
+		attributes := make([]string, len(rdn.Attributes))
+		for j, attribute := range rdn.Attributes {
+			attributes[j] = strings.ToLower(attribute.Type) + "=" + dn.EscapeValue(attribute.Value)
+		}
+		rdns[i] = strings.Join(attributes, "+")
+	}
+
+	return strings.Join(rdns, ","), nil
+}
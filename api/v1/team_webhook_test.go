@@ -0,0 +1,123 @@
+/*
+Copyright 2023.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Team webhook", func() {
+	BeforeEach(requireEnvtest)
+
+	newTeam := func(name string, spec TeamSpec) *Team {
+		return &Team{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       spec,
+		}
+	}
+
+	It("normalises subject and member DNs", func() {
+		team := newTeam("normalised", TeamSpec{
+			Subjects: []string{"UID=jdoe, OU=People,DC=example,DC=org"},
+			Members:  []TeamMember{{DN: "CN=Jane Doe , ou=People,dc=example,dc=org"}},
+		})
+		Expect(k8sClient.Create(ctx, team)).To(Succeed())
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(team), team)).To(Succeed())
+		Expect(team.Spec.Subjects).To(Equal([]string{"uid=jdoe,ou=People,dc=example,dc=org"}))
+		Expect(team.Spec.Members[0].DN).To(Equal("cn=Jane Doe,ou=People,dc=example,dc=org"))
+	})
+
+	It("rejects malformed DNs", func() {
+		err := k8sClient.Create(ctx, newTeam("malformed", TeamSpec{Subjects: []string{"jdoe"}}))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+	})
+
+	It("rejects malformed member filters", func() {
+		err := k8sClient.Create(ctx, newTeam("filter", TeamSpec{MemberFilter: "(department=sre"}))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+	})
+
+	It("rejects Teams without any member", func() {
+		err := k8sClient.Create(ctx, newTeam("empty", TeamSpec{}))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+	})
+
+	It("rejects changing the Directory", func() {
+		team := newTeam("moved", TeamSpec{Subjects: []string{"uid=jdoe,ou=people,dc=example,dc=org"}})
+		Expect(k8sClient.Create(ctx, team)).To(Succeed())
+
+		team.Spec.Directory = "contractors"
+		err := k8sClient.Update(ctx, team)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+	})
+
+	It("lets a Team created before the current rules be deleted", func() {
+		team := newTeam("legacy", TeamSpec{Subjects: []string{"jdoe"}})
+		team.Finalizers = []string{"app.wellerman.bouchaud.org/test"}
+		withoutValidation(func() {
+			Eventually(func() error { return k8sClient.Create(ctx, team) }).Should(Succeed())
+		})
+
+		Expect(k8sClient.Delete(ctx, team)).To(Succeed())
+		Eventually(func() error {
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(team), team); err != nil {
+				return err
+			}
+			team.Finalizers = nil
+			return k8sClient.Update(ctx, team)
+		}).Should(Succeed())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(team), team))).To(BeTrue())
+	})
+})
+
+var _ = Describe("Team updates", func() {
+	It("only validates the subjects and members that changed", func() {
+		old := &Team{Spec: TeamSpec{
+			Subjects: []string{"jdoe"},
+			Members:  []TeamMember{{DN: "Jane Doe"}},
+		}}
+
+		updated := old.DeepCopy()
+		Expect(updated.ValidateUpdate(old)).To(Succeed())
+
+		updated.Spec.Subjects = append(updated.Spec.Subjects, "jsmith")
+		Expect(apierrors.IsInvalid(updated.ValidateUpdate(old))).To(BeTrue())
+
+		updated.Spec.Subjects[1] = "uid=jsmith,ou=people,dc=example,dc=org"
+		Expect(updated.ValidateUpdate(old)).To(Succeed())
+	})
+
+	It("does not validate ClusterTeams being deleted", func() {
+		old := &ClusterTeam{Spec: TeamSpec{Subjects: []string{"jdoe"}}}
+
+		updated := old.DeepCopy()
+		updated.Spec.Directory = "contractors"
+		Expect(updated.ValidateUpdate(old)).NotTo(Succeed())
+
+		now := metav1.Now()
+		updated.DeletionTimestamp = &now
+		Expect(updated.ValidateUpdate(old)).To(Succeed())
+	})
+})
+
+var _ = Describe("normalizeDN", func() {
+	It("keeps escaped and non ASCII values", func() {
+		dn, err := normalizeDN(`CN=Doe\, José,OU=People`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dn).To(Equal(`cn=Doe\, José,ou=People`))
+	})
+
+	It("escapes values as the group DNs written by the LDAP client", func() {
+		dn, err := normalizeDN(`CN=a\=b,OU=People`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dn).To(Equal(`cn=a\=b,ou=People`))
+	})
+})
@@ -0,0 +1,73 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"sync"
+)
+
+// closer is a backend client holding connections, closed once it is no longer
+// used.
+type closer interface {
+	Close()
+}
+
+// clientCache keeps a backend client per resource name, along with the version
+// of the resource and of the Secrets it was built from. A client is rebuilt
+// when that version changes, and closed when it is replaced or forgotten.
+// Directories and GitlabInstances share it.
+type clientCache[C closer] struct {
+	mu      sync.Mutex
+	clients map[string]cachedClient[C]
+}
+
+type cachedClient[C closer] struct {
+	version string
+	client  C
+}
+
+// cacheVersion joins the versions a client depends on.
+func cacheVersion(versions ...string) string {
+	return strings.Join(versions, "/")
+}
+
+// load returns the client cached for name at version, or builds and caches it
+// with build, closing the client it replaces.
+func (c *clientCache[C]) load(name, version string, build func() (C, error)) (C, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.clients[name]
+	if ok && cached.version == version {
+		return cached.client, nil
+	}
+
+	client, err := build()
+	if err != nil {
+		return client, err
+	}
+
+	if c.clients == nil {
+		c.clients = map[string]cachedClient[C]{}
+	}
+	c.clients[name] = cachedClient[C]{version: version, client: client}
+	if ok {
+		cached.client.Close()
+	}
+
+	return client, nil
+}
+
+// forget closes and drops the client of name, whose resource no longer exists.
+func (c *clientCache[C]) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.clients[name]; ok {
+		cached.client.Close()
+		delete(c.clients, name)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type fakeCloser struct {
+	name   string
+	closed bool
+}
+
+func (c *fakeCloser) Close() {
+	c.closed = true
+}
+
+var _ = Describe("clientCache", func() {
+	var (
+		cache clientCache[*fakeCloser]
+		built int
+	)
+
+	BeforeEach(func() {
+		cache = clientCache[*fakeCloser]{}
+		built = 0
+	})
+
+	build := func(name string) func() (*fakeCloser, error) {
+		return func() (*fakeCloser, error) {
+			built++
+			return &fakeCloser{name: name}, nil
+		}
+	}
+
+	It("reuses the client built for the same version", func() {
+		first, err := cache.load("corp", "1", build("first"))
+		Expect(err).NotTo(HaveOccurred())
+		second, err := cache.load("corp", "1", build("second"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(second).To(BeIdenticalTo(first))
+		Expect(built).To(Equal(1))
+	})
+
+	It("closes the client it replaces when the version changes", func() {
+		first, _ := cache.load("corp", "1", build("first"))
+		second, err := cache.load("corp", "2", build("second"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(second.name).To(Equal("second"))
+		Expect(first.closed).To(BeTrue())
+		Expect(second.closed).To(BeFalse())
+	})
+
+	It("keeps the current client when a new one cannot be built", func() {
+		first, _ := cache.load("corp", "1", build("first"))
+		_, err := cache.load("corp", "2", func() (*fakeCloser, error) { return nil, errors.New("invalid") })
+		Expect(err).To(MatchError("invalid"))
+		Expect(first.closed).To(BeFalse())
+
+		again, _ := cache.load("corp", "1", build("again"))
+		Expect(again).To(BeIdenticalTo(first))
+	})
+
+	It("closes and drops the clients it forgets", func() {
+		first, _ := cache.load("corp", "1", build("first"))
+		cache.forget("corp")
+		cache.forget("unknown")
+		Expect(first.closed).To(BeTrue())
+
+		second, _ := cache.load("corp", "1", build("second"))
+		Expect(second.name).To(Equal("second"))
+	})
+})
+
+// countingReader counts the reads sent to the API server.
+type countingReader struct {
+	client.Reader
+	gets int
+}
+
+func (r *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	r.gets++
+	return r.Reader.Get(ctx, key, obj, opts...)
+}
+
+var _ = Describe("secretCache", func() {
+	var (
+		k8s     client.Client
+		api     *countingReader
+		secrets secretCache
+		secret  *v1.Secret
+	)
+
+	BeforeEach(func() {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "wellerman-system", Name: "ldap-bind"},
+			Data:       map[string][]byte{"password": []byte("first")},
+		}
+		k8s = newFakeClient(secret)
+		api = &countingReader{Reader: k8s}
+		secrets = secretCache{}
+	})
+
+	It("fetches the data of a Secret once per version", func() {
+		for i := 0; i < 3; i++ {
+			read, err := secrets.get(ctx, k8s, api, "wellerman-system", "ldap-bind")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(read.Data["password"]).To(BeEquivalentTo("first"))
+		}
+		Expect(api.gets).To(Equal(1))
+
+		secret.Data["password"] = []byte("second")
+		Expect(k8s.Update(ctx, secret)).To(Succeed())
+
+		read, err := secrets.get(ctx, k8s, api, "wellerman-system", "ldap-bind")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read.Data["password"]).To(BeEquivalentTo("second"))
+		Expect(api.gets).To(Equal(2))
+	})
+
+	It("forgets deleted Secrets", func() {
+		_, err := secrets.get(ctx, k8s, api, "wellerman-system", "ldap-bind")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8s.Delete(ctx, secret)).To(Succeed())
+
+		_, err = secrets.get(ctx, k8s, api, "wellerman-system", "ldap-bind")
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "got %v", err)
+		Expect(secrets.secrets).To(BeEmpty())
+	})
+})
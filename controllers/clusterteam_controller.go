@@ -0,0 +1,50 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+)
+
+// ClusterTeamReconciler reconciles a ClusterTeam object, the same way Teams are.
+type ClusterTeamReconciler struct {
+	*TeamReconciler
+}
+
+//+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=clusterteams,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=clusterteams/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=clusterteams/finalizers,verbs=update
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *ClusterTeamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return traced(ctx, "ClusterTeam", req, func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		return r.reconcileTeam(ctx, req, &appv1.ClusterTeam{})
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterTeamReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := r.setupIndexes(mgr, &appv1.ClusterTeam{}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&appv1.ClusterTeam{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(&source.Kind{Type: &appv1.ClusterTeam{}}, handler.EnqueueRequestsFromMapFunc(r.teamsIncluding)).
+		Watches(&source.Kind{Type: &appv1.Directory{}}, handler.EnqueueRequestsFromMapFunc(r.teamsUsing(true))).
+		Watches(&source.Kind{Type: &v1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.teamsUsingSecret(true)), builder.OnlyMetadata).
+		Complete(r)
+}
@@ -1,22 +1,252 @@
 package controllers
 
 import (
+	"context"
+	"fmt"
+	"sync"
+
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+	"github.com/vbouchaud/wellerman/internal/tracing"
 )
 
 const (
-	conditionInitialized = "Initialized"
-	conditionConfigured  = "Configured"
-	conditionReconciled  = "Reconciled"
+	// conditionReady sums up Synced and Degraded.
+	conditionReady = "Ready"
+	// conditionSynced tells whether the backend matches the spec.
+	conditionSynced = "Synced"
+	// conditionDegraded tells that part of the spec could not be applied,
+	// such as members that could not be found or paths that failed, all of
+	// them included.
+	conditionDegraded = "Degraded"
+
+	// conditionStalled tells that the last error cannot be fixed by retrying,
+	// only by a change of the spec or of the credentials.
+	conditionStalled = "Stalled"
+
+	conditionMembersResolved = "MembersResolved"
+	conditionConflict        = "Conflict"
 )
 
-func addCondition(l logr.Logger, c *[]metav1.Condition, t string, s metav1.ConditionStatus) {
-	l.Info("Setting condition", "status", t, "condition", s)
+// finalizer is a finalizer along with the name it had under the former
+// app.heidrun.bouchaud.org API group.
+type finalizer struct {
+	name   string
+	legacy string
+}
+
+// heldBy tells whether obj holds the finalizer under either name.
+func (f finalizer) heldBy(obj client.Object) bool {
+	return controllerutil.ContainsFinalizer(obj, f.name) || controllerutil.ContainsFinalizer(obj, f.legacy)
+}
+
+// remove removes the finalizer from obj under both names.
+func (f finalizer) remove(obj client.Object) {
+	controllerutil.RemoveFinalizer(obj, f.name)
+	controllerutil.RemoveFinalizer(obj, f.legacy)
+}
+
+// migrate replaces the legacy finalizer of obj by the current one, and tells
+// whether obj changed. Objects being deleted are left as is, finalizers can no
+// longer be added to them.
+func (f finalizer) migrate(obj client.Object) bool {
+	if obj.GetDeletionTimestamp() != nil || !controllerutil.ContainsFinalizer(obj, f.legacy) {
+		return false
+	}
+
+	controllerutil.RemoveFinalizer(obj, f.legacy)
+	controllerutil.AddFinalizer(obj, f.name)
+	return true
+}
+
+func addCondition(l logr.Logger, c *[]metav1.Condition, generation int64, t string, s metav1.ConditionStatus, reason, message string) {
+	if current := meta.FindStatusCondition(*c, t); current == nil || current.Status != s || current.Reason != reason {
+		l.Info("Setting condition", "condition", t, "status", s, "reason", reason)
+	}
 
 	meta.SetStatusCondition(c, metav1.Condition{
-		Type:   t,
-		Status: s,
+		Type:               t,
+		Status:             s,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
 	})
 }
+
+// setReady derives the Ready condition from Synced and Degraded, reporting the
+// reason of whichever is not fine.
+func setReady(l logr.Logger, c *[]metav1.Condition, generation int64) {
+	synced := meta.FindStatusCondition(*c, conditionSynced)
+	degraded := meta.FindStatusCondition(*c, conditionDegraded)
+
+	switch {
+	case synced == nil:
+		addCondition(l, c, generation, conditionReady, metav1.ConditionUnknown, "Pending", "")
+	case synced.Status != metav1.ConditionTrue:
+		addCondition(l, c, generation, conditionReady, metav1.ConditionFalse, synced.Reason, synced.Message)
+	case degraded != nil && degraded.Status == metav1.ConditionTrue:
+		addCondition(l, c, generation, conditionReady, metav1.ConditionFalse, degraded.Reason, degraded.Message)
+	default:
+		addCondition(l, c, generation, conditionReady, metav1.ConditionTrue, "Ready", "")
+	}
+}
+
+// setStalled sets the Stalled condition when err is terminal, and removes it
+// otherwise.
+func setStalled(l logr.Logger, c *[]metav1.Condition, generation int64, err error) {
+	if !backend.IsTerminal(err) {
+		meta.RemoveStatusCondition(c, conditionStalled)
+		return
+	}
+
+	addCondition(l, c, generation, conditionStalled, metav1.ConditionTrue, backend.KindOf(err).String(), err.Error())
+}
+
+// retryAfterError tells how a reconciliation that failed with err is retried:
+// only at the next resync for errors that retrying cannot fix, after the delay
+// asked by the backend when rate limited, and with backoff otherwise.
+func retryAfterError(resync ResyncOptions, err error) (ctrl.Result, error) {
+	switch {
+	case backend.IsTerminal(err):
+		return resync.requeue(ctrl.Result{}), nil
+	case backend.KindOf(err) == backend.RateLimited && backend.RetryAfterOf(err) > 0:
+		return ctrl.Result{RequeueAfter: backend.RetryAfterOf(err)}, nil
+	default:
+		return ctrl.Result{}, err
+	}
+}
+
+// traced runs reconcile for the resource of the given kind named by req in a
+// span, that the spans of the requests sent to the backends are children of.
+func traced(ctx context.Context, kind string, req ctrl.Request, reconcile func(context.Context, ctrl.Request) (ctrl.Result, error)) (ctrl.Result, error) {
+	ctx, span := tracing.Start(ctx, kind+" reconcile",
+		attribute.String("k8s.kind", kind),
+		semconv.K8SNamespaceName(req.Namespace),
+		attribute.String("k8s.name", req.Name),
+	)
+
+	result, err := reconcile(ctx, req)
+	tracing.End(span, err)
+
+	return result, err
+}
+
+// secretCache reads Secrets whose metadata only is cached by the manager, the
+// Secrets of the cluster not being ours to keep in memory. The data of the
+// Secrets read is fetched from the API server once per resource version.
+type secretCache struct {
+	mu      sync.Mutex
+	secrets map[types.NamespacedName]*v1.Secret
+}
+
+// get returns the Secret namespace/name, checking its version with cached and
+// fetching it with api when it changed. api defaults to cached.
+func (s *secretCache) get(ctx context.Context, cached, api client.Reader, namespace, name string) (*v1.Secret, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+
+	metadata := &metav1.PartialObjectMetadata{}
+	metadata.SetGroupVersionKind(v1.SchemeGroupVersion.WithKind("Secret"))
+	if err := cached.Get(ctx, key, metadata); err != nil {
+		if errors.IsNotFound(err) {
+			s.mu.Lock()
+			delete(s.secrets, key)
+			s.mu.Unlock()
+		}
+		return nil, err
+	}
+
+	s.mu.Lock()
+	secret, ok := s.secrets[key]
+	s.mu.Unlock()
+	if ok && secret.ResourceVersion == metadata.ResourceVersion {
+		return secret, nil
+	}
+
+	if api == nil {
+		api = cached
+	}
+	secret = &v1.Secret{}
+	if err := api.Get(ctx, key, secret); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.secrets == nil {
+		s.secrets = map[types.NamespacedName]*v1.Secret{}
+	}
+	s.secrets[key] = secret
+	s.mu.Unlock()
+
+	return secret, nil
+}
+
+// secretKey is how Secrets are referred to in indexes and logs.
+func secretKey(namespace, name string) string {
+	return types.NamespacedName{Namespace: namespace, Name: name}.String()
+}
+
+// secretValue returns the value of key in secret. A missing or empty key is an
+// error, as a missing Secret is, for credentials never to be silently empty.
+func secretValue(secret *v1.Secret, key string) (string, error) {
+	value := secret.Data[key]
+	if len(value) == 0 {
+		return "", backend.New(backend.Invalid, fmt.Sprintf("the key %q of the Secret %s is missing or empty", key, secretKey(secret.Namespace, secret.Name)))
+	}
+	return string(value), nil
+}
+
+// namespaceAllowed tells whether resources of namespace may use a Directory or
+// GitlabInstance allowing the given namespaces. Cluster-scoped resources always
+// may, and so do all namespaces when none is listed.
+func namespaceAllowed(allowed []string, namespace string) bool {
+	if namespace == "" || len(allowed) == 0 {
+		return true
+	}
+	for _, name := range allowed {
+		if name == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// errNamespaceNotAllowed is returned for a resource using a Directory or
+// GitlabInstance that does not allow its namespace.
+func errNamespaceNotAllowed(kind, name, namespace string) error {
+	return backend.New(backend.Invalid, fmt.Sprintf("the %s %s does not allow namespace %s", kind, name, namespace))
+}
+
+// setClientReady records on the Ready condition of a Directory or
+// GitlabInstance whether a client could be built from it, err telling why
+// not, and writes its status when that changed. A failed write is only
+// logged, it must not fail the reconciliation of the resources using it.
+func setClientReady(ctx context.Context, c client.Client, obj client.Object, conditions *[]metav1.Condition, err error) {
+	logger := log.FromContext(ctx)
+	original := append([]metav1.Condition(nil), *conditions...)
+
+	if err != nil {
+		addCondition(logger, conditions, obj.GetGeneration(), conditionReady, metav1.ConditionFalse, "ClientFailed", err.Error())
+	} else {
+		addCondition(logger, conditions, obj.GetGeneration(), conditionReady, metav1.ConditionTrue, "ClientReady", "")
+	}
+
+	if equality.Semantic.DeepEqual(original, *conditions) {
+		return
+	}
+	if err := c.Status().Update(ctx, obj); err != nil {
+		logger.Error(err, "Failed to update status.", "name", obj.GetName())
+	}
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+)
+
+var _ = DescribeTable("retryAfterError",
+	func(err error, result ctrl.Result, retried bool) {
+		got, gotErr := retryAfterError(ResyncOptions{}, err)
+		Expect(got).To(Equal(result))
+		if retried {
+			Expect(gotErr).To(MatchError(err))
+		} else {
+			Expect(gotErr).NotTo(HaveOccurred())
+		}
+	},
+	Entry("unclassified errors with backoff", errors.New("boom"), ctrl.Result{}, true),
+	Entry("transient errors with backoff", backend.New(backend.Transient, "unavailable"), ctrl.Result{}, true),
+	Entry("conflicts with backoff", backend.New(backend.Conflict, "no such attribute"), ctrl.Result{}, true),
+	Entry("rate limits without delay with backoff", backend.New(backend.RateLimited, "busy"), ctrl.Result{}, true),
+	Entry("rate limits after the delay asked",
+		&backend.Error{Kind: backend.RateLimited, Err: errors.New("too many requests"), RetryAfter: time.Minute},
+		ctrl.Result{RequeueAfter: time.Minute}, false),
+	Entry("invalid requests at the next resync only", backend.New(backend.Invalid, "invalid"), ctrl.Result{}, false),
+	Entry("refused credentials at the next resync only", backend.New(backend.Unauthorized, "forbidden"), ctrl.Result{}, false),
+)
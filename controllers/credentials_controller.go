@@ -0,0 +1,124 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/vbouchaud/wellerman/internal/credentials"
+)
+
+// CredentialsReconciler hands the value of a Secret key over to a backend
+// whenever the Secret changes.
+type CredentialsReconciler struct {
+	client.Client
+
+	// Name identifies the credentials, in logs and in the registry.
+	Name     string
+	Secret   types.NamespacedName
+	Key      string
+	Registry *credentials.Registry
+
+	// OnChange receives the new value of the key.
+	OnChange func(string) error
+
+	version string
+	secrets client.Reader
+}
+
+// Load reads the Secret once, before the manager cache is started, so that
+// backends are built with the current value.
+func (r *CredentialsReconciler) Load(ctx context.Context, reader client.Reader) (string, error) {
+	secret := &v1.Secret{}
+	if err := reader.Get(ctx, r.Secret, secret); err != nil {
+		return "", err
+	}
+
+	value, err := secretValue(secret, r.Key)
+	if err != nil {
+		return "", err
+	}
+
+	r.version = secret.ResourceVersion
+	r.Registry.Record(r.Name, r.Secret.String(), secret.ResourceVersion)
+
+	return value, nil
+}
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *CredentialsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("credentials", r.Name)
+
+	secret := &v1.Secret{}
+	if err := r.secrets.Get(ctx, req.NamespacedName, secret); err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Credentials Secret not found, keeping the current credentials.")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if secret.ResourceVersion == r.version {
+		return ctrl.Result{}, nil
+	}
+
+	value, err := secretValue(secret, r.Key)
+	if err != nil {
+		logger.Error(err, "Invalid credentials Secret, keeping the current credentials.")
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.OnChange(value); err != nil {
+		logger.Error(err, "Failed to reload credentials.")
+		return ctrl.Result{}, err
+	}
+
+	r.version = secret.ResourceVersion
+	r.Registry.Record(r.Name, r.Secret.String(), secret.ResourceVersion)
+	logger.Info("Credentials reloaded.", "version", secret.ResourceVersion)
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. The Secret is
+// watched through a cache of its own, restricted to it, the manager not
+// caching the Secrets of the cluster.
+func (r *CredentialsReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	secrets, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme:    mgr.GetScheme(),
+		Mapper:    mgr.GetRESTMapper(),
+		Namespace: r.Secret.Namespace,
+		SelectorsByObject: cache.SelectorsByObject{
+			&v1.Secret{}: {Field: fields.OneTermEqualSelector("metadata.name", r.Secret.Name)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := mgr.Add(secrets); err != nil {
+		return err
+	}
+	r.secrets = secrets
+
+	c, err := controller.New("credentials-"+r.Name, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	return c.Watch(source.NewKindWithCache(&v1.Secret{}, secrets), &handler.EnqueueRequestForObject{})
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/ldap"
+)
+
+// teamDirectoryIndex indexes Teams by the name of the Directory they use.
+const teamDirectoryIndex = "spec.directory"
+
+func indexTeamDirectory(obj client.Object) []string {
+	if directory := obj.(appv1.TeamObject).GetSpec().Directory; directory != "" {
+		return []string{directory}
+	}
+	return nil
+}
+
+// directorySecretIndex indexes Directories by the Secrets they read.
+const directorySecretIndex = "spec.secretRefs"
+
+func indexDirectorySecrets(obj client.Object) []string {
+	var keys []string
+
+	spec := obj.(*appv1.Directory).Spec
+	if ref := spec.BindSecretRef; ref != nil {
+		keys = append(keys, secretKey(ref.Namespace, ref.Name))
+	}
+	if ref := spec.TLS.CASecretRef; ref != nil {
+		keys = append(keys, secretKey(ref.Namespace, ref.Name))
+	}
+	if ref := spec.TLS.ClientCertificateSecretRef; ref != nil {
+		keys = append(keys, secretKey(ref.Namespace, ref.Name))
+	}
+
+	return keys
+}
+
+// DirectoryDefaults holds the settings of the clients built for Directories
+// that are not part of the Directory itself.
+type DirectoryDefaults struct {
+	GroupDNTemplate string
+	Ownership       ldap.OwnershipOptions
+	Pool            ldap.PoolOptions
+}
+
+// ldapFor returns the client of the Directory a Team uses, the operator one
+// when it does not reference any.
+func (r *TeamReconciler) ldapFor(ctx context.Context, team appv1.TeamObject) (GroupDirectory, error) {
+	name := team.GetSpec().Directory
+	if name == "" {
+		return r.Ldap, nil
+	}
+
+	directory := &appv1.Directory{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, directory); err != nil {
+		if errors.IsNotFound(err) {
+			r.directories.forget(name)
+		}
+		return nil, err
+	}
+
+	if !namespaceAllowed(directory.Spec.AllowedNamespaces, team.GetNamespace()) {
+		return nil, errNamespaceNotAllowed("Directory", name, team.GetNamespace())
+	}
+
+	c, err := r.directoryClient(ctx, directory)
+	setClientReady(ctx, r.Client, directory, &directory.Status.Conditions, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// directoryClient returns the cached client of directory, built anew when the
+// Directory or the Secrets it reads changed.
+func (r *TeamReconciler) directoryClient(ctx context.Context, directory *appv1.Directory) (*ldap.Client, error) {
+	name := directory.Name
+	spec := directory.Spec
+	versions := []string{fmt.Sprint(directory.Generation)}
+
+	password := ""
+	if ref := spec.BindSecretRef; ref != nil {
+		secret, err := r.getSecret(ctx, ref.Namespace, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		if password, err = secretValue(secret, ref.Key); err != nil {
+			return nil, err
+		}
+		versions = append(versions, secret.ResourceVersion)
+		r.Credentials.Record("directory/"+name, secretKey(ref.Namespace, ref.Name), secret.ResourceVersion)
+	}
+
+	tlsOptions := ldap.TLSOptions{
+		StartTLS:           spec.TLS.StartTLS,
+		ServerName:         spec.TLS.ServerName,
+		InsecureSkipVerify: spec.TLS.InsecureSkipVerify,
+		SASLExternal:       spec.TLS.SASLExternal,
+	}
+	if ref := spec.TLS.CASecretRef; ref != nil {
+		secret, err := r.getSecret(ctx, ref.Namespace, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		tlsOptions.CA = secret.Data[ref.Key]
+		versions = append(versions, secret.ResourceVersion)
+	}
+	if ref := spec.TLS.ClientCertificateSecretRef; ref != nil {
+		secret, err := r.getSecret(ctx, ref.Namespace, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		tlsOptions.Cert = secret.Data[v1.TLSCertKey]
+		tlsOptions.Key = secret.Data[v1.TLSPrivateKeyKey]
+		versions = append(versions, secret.ResourceVersion)
+	}
+
+	return r.directories.load(name, cacheVersion(versions...), func() (*ldap.Client, error) {
+		poolOptions := r.DirectoryDefaults.Pool
+		poolOptions.Name = "directory/" + name
+
+		groupDNTemplate := spec.GroupDNTemplate
+		if groupDNTemplate == "" {
+			groupDNTemplate = r.DirectoryDefaults.GroupDNTemplate
+		}
+
+		c, err := ldap.NewInstance(
+			spec.URL,
+			spec.BindDN,
+			password,
+			spec.GroupSearchBase,
+			spec.Schema.GroupNameProperty,
+			groupDNTemplate,
+			[]string{},
+			ldap.UserSearchOptions{
+				Base:        spec.UserSearchBase,
+				Scope:       spec.UserSearchScope,
+				Filter:      spec.UserSearchFilter,
+				EmailFilter: spec.UserEmailFilter,
+			},
+			ldap.SchemaOptions{
+				GroupObjectClass: spec.Schema.GroupObjectClass,
+				MemberAttribute:  spec.Schema.MemberAttribute,
+			},
+			r.DirectoryDefaults.Ownership,
+			tlsOptions,
+			poolOptions,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Directory %s: %w", name, err)
+		}
+		return c, nil
+	})
+}
+
+// teamsUsing enqueues the Teams, or ClusterTeams, that use the given Directory.
+func (r *TeamReconciler) teamsUsing(cluster bool) func(client.Object) []reconcile.Request {
+	return func(obj client.Object) []reconcile.Request {
+		teams, err := r.listTeams(context.Background(), cluster, client.MatchingFields{teamDirectoryIndex: obj.GetName()})
+		if err != nil {
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(teams))
+		for _, team := range teams {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(team)})
+		}
+
+		return requests
+	}
+}
+
+// teamsUsingSecret enqueues the Teams, or ClusterTeams, that use a Directory
+// reading the given Secret.
+func (r *TeamReconciler) teamsUsingSecret(cluster bool) func(client.Object) []reconcile.Request {
+	return func(obj client.Object) []reconcile.Request {
+		directories := &appv1.DirectoryList{}
+		if err := r.List(context.Background(), directories, client.MatchingFields{directorySecretIndex: secretKey(obj.GetNamespace(), obj.GetName())}); err != nil {
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, directory := range directories.Items {
+			requests = append(requests, r.teamsUsing(cluster)(&directory)...)
+		}
+
+		return requests
+	}
+}
+
+// getSecret returns the Secret namespace/name, see secretCache.
+func (r *TeamReconciler) getSecret(ctx context.Context, namespace, name string) (*v1.Secret, error) {
+	return r.secrets.get(ctx, r.Client, r.APIReader, namespace, name)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+)
+
+var _ = Describe("Directories", func() {
+	const memberDN = "uid=jdoe,ou=people,dc=example,dc=org"
+
+	var (
+		k8s   client.Client
+		teams *TeamReconciler
+	)
+
+	newDirectory := func(allowed ...string) *appv1.Directory {
+		return &appv1.Directory{
+			ObjectMeta: metav1.ObjectMeta{Name: "corp"},
+			Spec: appv1.DirectorySpec{
+				URL:               "ldaps://ldap.example.org",
+				GroupSearchBase:   "ou=groups,dc=example,dc=org",
+				AllowedNamespaces: allowed,
+			},
+		}
+	}
+	newTeam := func(namespace string) *appv1.Team {
+		return &appv1.Team{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "ops",
+				Namespace:  namespace,
+				Finalizers: []string{teamFinalizer.name},
+			},
+			Spec: appv1.TeamSpec{Directory: "corp", Subjects: []string{memberDN}},
+		}
+	}
+	conditionOf := func(obj client.Object, conditions *[]metav1.Condition, t string) *metav1.Condition {
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(obj), obj)).To(Succeed())
+		return meta.FindStatusCondition(*conditions, t)
+	}
+
+	BeforeEach(func() {
+		k8s = newFakeClient()
+		teams = &TeamReconciler{Client: k8s, Scheme: scheme.Scheme, Recorder: newRecorder(), Ldap: newFakeDirectory()}
+	})
+
+	It("records on the Directory whether a client could be built from it", func() {
+		directory := newDirectory()
+		Expect(k8s.Create(ctx, directory)).To(Succeed())
+
+		_, err := teams.ldapFor(ctx, newTeam("default"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conditionOf(directory, &directory.Status.Conditions, conditionReady).Reason).To(Equal("ClientReady"))
+
+		directory.Spec.GroupDNTemplate = "cn={{ .GroupName"
+		directory.Generation++
+		Expect(k8s.Update(ctx, directory)).To(Succeed())
+
+		_, err = teams.ldapFor(ctx, newTeam("default"))
+		Expect(err).To(HaveOccurred())
+		Expect(conditionOf(directory, &directory.Status.Conditions, conditionReady).Reason).To(Equal("ClientFailed"))
+	})
+
+	It("refuses a bind Secret without the password key", func() {
+		directory := newDirectory()
+		directory.Spec.BindDN = "cn=admin,dc=example,dc=org"
+		directory.Spec.BindSecretRef = &appv1.SecretKeyReference{Namespace: "wellerman-system", Name: "corp-bind", Key: "password"}
+		Expect(k8s.Create(ctx, directory)).To(Succeed())
+		Expect(k8s.Create(ctx, &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "wellerman-system", Name: "corp-bind"},
+			Data:       map[string][]byte{"passwd": []byte("secret")},
+		})).To(Succeed())
+
+		_, err := teams.ldapFor(ctx, newTeam("default"))
+		Expect(err).To(MatchError(ContainSubstring(`the key "password" of the Secret wellerman-system/corp-bind is missing or empty`)))
+		Expect(conditionOf(directory, &directory.Status.Conditions, conditionReady).Reason).To(Equal("ClientFailed"))
+	})
+
+	It("refuses Teams of namespaces the Directory does not allow", func() {
+		Expect(k8s.Create(ctx, newDirectory("platform"))).To(Succeed())
+
+		team := newTeam("default")
+		Expect(k8s.Create(ctx, team)).To(Succeed())
+
+		_, err := teams.Reconcile(ctx, requestFor(team))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conditionOf(team, &team.Status.Conditions, conditionSynced).Reason).To(Equal("DirectoryNotAllowed"))
+		Expect(conditionOf(team, &team.Status.Conditions, conditionStalled)).NotTo(BeNil())
+
+		_, err = teams.ldapFor(ctx, newTeam("platform"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = teams.ldapFor(ctx, &appv1.ClusterTeam{Spec: appv1.TeamSpec{Directory: "corp"}})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("keeps a deleted Team whose Directory is missing until its deletion is confirmed", func() {
+		team := newTeam("default")
+		team.Status.DistinguishedName = "cn=ops,ou=groups,dc=example,dc=org"
+		Expect(k8s.Create(ctx, team)).To(Succeed())
+		Expect(k8s.Delete(ctx, team)).To(Succeed())
+
+		_, err := teams.Reconcile(ctx, requestFor(team))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conditionOf(team, &team.Status.Conditions, conditionSynced).Reason).To(Equal("DirectoryUnavailable"))
+		Expect(team.Finalizers).To(ConsistOf(teamFinalizer.name))
+
+		team.Annotations = map[string]string{appv1.ConfirmDeletionAnnotation: "true"}
+		Expect(k8s.Update(ctx, team)).To(Succeed())
+
+		_, err = teams.Reconcile(ctx, requestFor(team))
+		Expect(err).NotTo(HaveOccurred())
+		err = k8s.Get(ctx, client.ObjectKeyFromObject(team), team)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "got %v", err)
+	})
+})
@@ -0,0 +1,81 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// DriftPolicyCorrect writes back the spec over changes made outside of
+	// wellerman.
+	DriftPolicyCorrect = "correct"
+	// DriftPolicyReport only reports changes made outside of wellerman, the
+	// spec still being applied when it changes.
+	DriftPolicyReport = "report"
+)
+
+// conditionDrifted tells whether the backend was found different from a spec
+// that was already applied.
+const conditionDrifted = "Drifted"
+
+// ResyncOptions sets how often resources are reconciled without any change of
+// their own, and what is done of the drift then found.
+type ResyncOptions struct {
+	// Period is the base duration between two resyncs, 0 disabling them.
+	Period time.Duration
+	// Jitter is the maximum factor of Period randomly added to it, spreading
+	// the resyncs of resources created together.
+	Jitter float64
+	// DriftPolicy is one of DriftPolicyCorrect or DriftPolicyReport.
+	DriftPolicy string
+}
+
+// requeue returns result with the next resync scheduled, keeping an earlier
+// requeue if result already holds one.
+func (o ResyncOptions) requeue(result ctrl.Result) ctrl.Result {
+	if o.Period <= 0 {
+		return result
+	}
+
+	after := wait.Jitter(o.Period, o.Jitter)
+	if result.RequeueAfter == 0 || after < result.RequeueAfter {
+		result.RequeueAfter = after
+	}
+
+	return result
+}
+
+// reportOnly tells whether drift must be computed without being corrected.
+func (o ResyncOptions) reportOnly() bool {
+	return o.DriftPolicy == DriftPolicyReport
+}
+
+// specApplied tells whether the spec at generation was already synced, any
+// change found from then on being drift.
+func specApplied(c []metav1.Condition, generation int64) bool {
+	synced := meta.FindStatusCondition(c, conditionSynced)
+	return synced != nil && synced.ObservedGeneration == generation && synced.Status == metav1.ConditionTrue
+}
+
+// setDrifted records the changes found while the spec was already applied,
+// corrected unless reportOnly.
+func setDrifted(l logr.Logger, c *[]metav1.Condition, generation int64, drift []string, reportOnly bool) {
+	switch {
+	case len(drift) == 0:
+		addCondition(l, c, generation, conditionDrifted, metav1.ConditionFalse, "InSync", "")
+	case reportOnly:
+		addCondition(l, c, generation, conditionDrifted, metav1.ConditionTrue, "DriftDetected", strings.Join(drift, "; "))
+	default:
+		addCondition(l, c, generation, conditionDrifted, metav1.ConditionTrue, "DriftCorrected", strings.Join(drift, "; "))
+	}
+}
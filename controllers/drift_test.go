@@ -0,0 +1,69 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("Resync", func() {
+	It("does not requeue when disabled", func() {
+		Expect(ResyncOptions{}.requeue(ctrl.Result{})).To(Equal(ctrl.Result{}))
+		Expect(ResyncOptions{}.requeue(ctrl.Result{RequeueAfter: time.Minute})).To(Equal(ctrl.Result{RequeueAfter: time.Minute}))
+	})
+
+	It("requeues within the jittered period", func() {
+		resync := ResyncOptions{Period: time.Hour, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			after := resync.requeue(ctrl.Result{}).RequeueAfter
+			Expect(after).To(BeNumerically(">=", time.Hour))
+			Expect(after).To(BeNumerically("<=", 90*time.Minute))
+		}
+	})
+
+	It("keeps an earlier requeue", func() {
+		resync := ResyncOptions{Period: time.Hour, Jitter: 0.5}
+		Expect(resync.requeue(ctrl.Result{RequeueAfter: time.Minute}).RequeueAfter).To(Equal(time.Minute))
+		Expect(resync.requeue(ctrl.Result{RequeueAfter: 2 * time.Hour}).RequeueAfter).To(BeNumerically("<=", 90*time.Minute))
+	})
+
+	It("tells whether a spec was applied", func() {
+		var conditions []metav1.Condition
+		Expect(specApplied(conditions, 2)).To(BeFalse())
+
+		addCondition(logr.Discard(), &conditions, 1, conditionSynced, metav1.ConditionTrue, "Synced", "")
+		Expect(specApplied(conditions, 2)).To(BeFalse())
+
+		addCondition(logr.Discard(), &conditions, 2, conditionSynced, metav1.ConditionFalse, "DryRun", "")
+		Expect(specApplied(conditions, 2)).To(BeFalse())
+
+		addCondition(logr.Discard(), &conditions, 2, conditionSynced, metav1.ConditionTrue, "Synced", "")
+		Expect(specApplied(conditions, 2)).To(BeTrue())
+	})
+
+	DescribeTable("reports drift",
+		func(drift []string, reportOnly bool, status metav1.ConditionStatus, reason string) {
+			var conditions []metav1.Condition
+			setDrifted(logr.Discard(), &conditions, 1, drift, reportOnly)
+
+			drifted := meta.FindStatusCondition(conditions, conditionDrifted)
+			Expect(drifted).NotTo(BeNil())
+			Expect(drifted.Status).To(Equal(status))
+			Expect(drifted.Reason).To(Equal(reason))
+		},
+		Entry("in sync", nil, false, metav1.ConditionFalse, "InSync"),
+		Entry("in sync, report only", nil, true, metav1.ConditionFalse, "InSync"),
+		Entry("corrected", []string{"name changed"}, false, metav1.ConditionTrue, "DriftCorrected"),
+		Entry("report only", []string{"name changed"}, true, metav1.ConditionTrue, "DriftDetected"),
+	)
+})
@@ -0,0 +1,216 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/backend"
+	"github.com/vbouchaud/wellerman/internal/ldap"
+)
+
+// indexes are the field indexes of the manager, which the fake client does not
+// know about.
+var indexes = map[string]client.IndexerFunc{
+	teamDNIndex:          indexTeamDN,
+	teamMemberIndex:      indexTeamMembers,
+	teamDirectoryIndex:   indexTeamDirectory,
+	projectInstanceIndex: indexProjectInstances,
+	projectPathIndex:     indexProjectPaths,
+	projectIDIndex:       indexProjectIDs,
+}
+
+// indexedClient filters the lists of the fake client with indexes, as the
+// cache of the manager does.
+type indexedClient struct {
+	client.WithWatch
+}
+
+func newFakeClient(objs ...client.Object) client.Client {
+	return indexedClient{fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()}
+}
+
+func (c indexedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := c.WithWatch.List(ctx, list, opts...); err != nil {
+		return err
+	}
+
+	listOptions := (&client.ListOptions{}).ApplyOptions(opts)
+	if listOptions.FieldSelector == nil || listOptions.FieldSelector.Empty() {
+		return nil
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+
+	var kept []runtime.Object
+	for _, item := range items {
+		if matchesFields(item.(client.Object), listOptions) {
+			kept = append(kept, item)
+		}
+	}
+
+	return meta.SetList(list, kept)
+}
+
+func matchesFields(obj client.Object, listOptions *client.ListOptions) bool {
+	for _, requirement := range listOptions.FieldSelector.Requirements() {
+		index, ok := indexes[requirement.Field]
+		if !ok {
+			panic(fmt.Sprintf("no index for field %s", requirement.Field))
+		}
+
+		found := false
+		for _, value := range index(obj) {
+			found = found || value == requirement.Value
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeDirectory is a GroupDirectory holding its groups in memory and recording
+// the writes sent to it.
+type fakeDirectory struct {
+	mu sync.Mutex
+
+	// users maps logins and emails to DNs.
+	users map[string]string
+	// filters maps member filters to the DNs they match.
+	filters map[string][]string
+	// groups maps the DN of the groups to their members.
+	groups map[string][]string
+	// errs fails the operations they are keyed by, such as "ReconcileGroup".
+	errs map[string]error
+	// hold, when set, blocks ReconcileGroup until it is closed, after sending
+	// the group name to entered.
+	hold    chan struct{}
+	entered chan string
+
+	writes []string
+	// previous records the previous DN ReconcileGroup was called with.
+	previous []string
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		users:   map[string]string{},
+		filters: map[string][]string{},
+		groups:  map[string][]string{},
+		errs:    map[string]error{},
+	}
+}
+
+func (d *fakeDirectory) Writes() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.writes...)
+}
+
+func (d *fakeDirectory) GroupDN(team appv1.TeamObject, groupName string) (string, error) {
+	return fmt.Sprintf("cn=%s,ou=groups,dc=example,dc=org", groupName), nil
+}
+
+func (d *fakeDirectory) LegacyGroupDN(team appv1.TeamObject) string {
+	return fmt.Sprintf("cn=%s,ou=legacy,dc=example,dc=org", team.GetName())
+}
+
+func (d *fakeDirectory) ReconcileGroup(ctx context.Context, team appv1.TeamObject, groupName, previous string, members []string, dryRun bool) (string, error, []ldap.Change) {
+	if d.hold != nil {
+		d.entered <- groupName
+		<-d.hold
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.previous = append(d.previous, previous)
+	dn, _ := d.GroupDN(team, groupName)
+	if err := d.errs["ReconcileGroup"]; err != nil {
+		return "", err, nil
+	}
+
+	if strings.Join(d.groups[dn], ";") == strings.Join(members, ";") {
+		return dn, nil, nil
+	}
+
+	change := ldap.Change{Reason: "GroupUpdated", Message: fmt.Sprintf("Updated group %s", dn)}
+	if !dryRun {
+		d.groups[dn] = append([]string(nil), members...)
+		d.writes = append(d.writes, "reconcile "+dn)
+	}
+	return dn, nil, []ldap.Change{change}
+}
+
+func (d *fakeDirectory) DeleteGroup(ctx context.Context, groupDN string, dryRun bool) (error, []ldap.Change) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.errs["DeleteGroup"]; err != nil {
+		return err, nil
+	}
+	if _, ok := d.groups[groupDN]; !ok {
+		return nil, nil
+	}
+
+	if !dryRun {
+		delete(d.groups, groupDN)
+		d.writes = append(d.writes, "delete "+groupDN)
+	}
+	return nil, []ldap.Change{{Reason: "GroupDeleted", Message: fmt.Sprintf("Deleted group %s", groupDN)}}
+}
+
+func (d *fakeDirectory) ResolveUser(ctx context.Context, login string) (string, error) {
+	return d.resolve(login)
+}
+
+func (d *fakeDirectory) ResolveEmail(ctx context.Context, email string) (string, error) {
+	return d.resolve(email)
+}
+
+func (d *fakeDirectory) resolve(key string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if dn, ok := d.users[key]; ok {
+		return dn, nil
+	}
+	return "", ldap.ErrUserNotFound
+}
+
+func (d *fakeDirectory) SearchUsers(ctx context.Context, filter string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dns, ok := d.filters[filter]
+	if !ok {
+		return nil, backend.New(backend.Invalid, "unknown filter")
+	}
+	return dns, nil
+}
+
+func newRecorder() *record.FakeRecorder {
+	return record.NewFakeRecorder(100)
+}
+
+func requestFor(obj client.Object) ctrl.Request {
+	return ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+}
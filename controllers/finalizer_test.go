@@ -0,0 +1,220 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/gitlab"
+	"github.com/vbouchaud/wellerman/internal/gitlab/gitlabtest"
+)
+
+var _ = Describe("Legacy finalizers", func() {
+	const memberDN = "uid=jdoe,ou=people,dc=example,dc=org"
+
+	newTeam := func(name string) *appv1.Team {
+		return &appv1.Team{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  "default",
+				Finalizers: []string{teamFinalizer.legacy},
+			},
+			Spec: appv1.TeamSpec{Subjects: []string{memberDN}},
+		}
+	}
+	newProject := func(name string) *appv1.Project {
+		return &appv1.Project{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  "default",
+				Finalizers: []string{projectFinalizer.legacy},
+			},
+			Spec: appv1.ProjectSpec{Paths: []appv1.ProjectPath{{Path: "infra/" + name}}},
+		}
+	}
+
+	var (
+		k8s       client.Client
+		directory *fakeDirectory
+		server    *gitlabtest.Server
+		teams     *TeamReconciler
+		projects  *ProjectReconciler
+	)
+
+	BeforeEach(func() {
+		k8s = newFakeClient()
+		directory = newFakeDirectory()
+		server = gitlabtest.NewServer()
+		DeferCleanup(server.Close)
+
+		instance, err := gitlab.NewInstance(server.URL, "token", gitlab.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		teams = &TeamReconciler{Client: k8s, Scheme: scheme.Scheme, Recorder: record.NewFakeRecorder(100), Ldap: directory}
+		projects = &ProjectReconciler{Client: k8s, Scheme: scheme.Scheme, Recorder: record.NewFakeRecorder(100), Gitlab: instance}
+	})
+
+	reconcile := func(r interface {
+		Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error)
+	}, obj client.Object) {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	expectGone := func(obj client.Object) {
+		err := k8s.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "got %v", err)
+	}
+
+	It("migrates the finalizer of a Team and still deletes its group", func() {
+		team := newTeam("migrated")
+		Expect(k8s.Create(ctx, team)).To(Succeed())
+
+		reconcile(teams, team)
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(team), team)).To(Succeed())
+		Expect(team.Finalizers).To(ConsistOf(teamFinalizer.name))
+		Expect(team.Status.DistinguishedName).To(Equal("cn=migrated,ou=groups,dc=example,dc=org"))
+
+		Expect(k8s.Delete(ctx, team)).To(Succeed())
+		reconcile(teams, team)
+		expectGone(team)
+		Expect(directory.Writes()).To(Equal([]string{
+			"reconcile cn=migrated,ou=groups,dc=example,dc=org",
+			"delete cn=migrated,ou=groups,dc=example,dc=org",
+		}))
+	})
+
+	It("deletes the group of a Team deleted before its finalizer was migrated", func() {
+		directory.groups["cn=unmigrated,ou=groups,dc=example,dc=org"] = []string{memberDN}
+
+		team := newTeam("unmigrated")
+		Expect(k8s.Create(ctx, team)).To(Succeed())
+		Expect(k8s.Delete(ctx, team)).To(Succeed())
+
+		reconcile(teams, team)
+		expectGone(team)
+		Expect(directory.Writes()).To(Equal([]string{"delete cn=unmigrated,ou=groups,dc=example,dc=org"}))
+	})
+
+	It("migrates the finalizer of a Project and still archives its project", func() {
+		project := newProject("migrated")
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+
+		reconcile(projects, project)
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(project), project)).To(Succeed())
+		Expect(project.Finalizers).To(ConsistOf(projectFinalizer.name))
+
+		created := server.Project("infra/migrated")
+		Expect(created).NotTo(BeNil())
+
+		Expect(k8s.Delete(ctx, project)).To(Succeed())
+		reconcile(projects, project)
+		expectGone(project)
+		Expect(server.Writes()).To(ContainElement(fmt.Sprintf("POST /projects/%d/archive", created.ID)))
+		Expect(server.Project("infra/migrated").Archived).To(BeTrue())
+	})
+
+	It("archives the project of a Project deleted before its finalizer was migrated", func() {
+		existing := server.AddProject("infra/unmigrated")
+
+		project := newProject("unmigrated")
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+		Expect(k8s.Delete(ctx, project)).To(Succeed())
+
+		reconcile(projects, project)
+		expectGone(project)
+		Expect(server.Writes()).To(Equal([]string{fmt.Sprintf("POST /projects/%d/archive", existing.ID)}))
+	})
+})
+
+var _ = Describe("Dry-run deletion", func() {
+	const memberDN = "uid=jdoe,ou=people,dc=example,dc=org"
+
+	var (
+		k8s       client.Client
+		directory *fakeDirectory
+		server    *gitlabtest.Server
+		teams     *TeamReconciler
+		projects  *ProjectReconciler
+	)
+
+	BeforeEach(func() {
+		k8s = newFakeClient()
+		directory = newFakeDirectory()
+		server = gitlabtest.NewServer()
+		DeferCleanup(server.Close)
+
+		instance, err := gitlab.NewInstance(server.URL, "token", gitlab.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		teams = &TeamReconciler{Client: k8s, Scheme: scheme.Scheme, Recorder: newRecorder(), Ldap: directory}
+		projects = &ProjectReconciler{Client: k8s, Scheme: scheme.Scheme, Recorder: newRecorder(), Gitlab: instance}
+	})
+
+	dryRun := func() map[string]string {
+		return map[string]string{appv1.ReconcileAnnotation: string(appv1.ReconcileDryRun)}
+	}
+
+	It("keeps a Team and its group until it leaves dry-run", func() {
+		const groupDN = "cn=planned,ou=groups,dc=example,dc=org"
+		directory.groups[groupDN] = []string{memberDN}
+
+		team := &appv1.Team{
+			ObjectMeta: metav1.ObjectMeta{Name: "planned", Namespace: "default", Annotations: dryRun(), Finalizers: []string{teamFinalizer.name}},
+			Spec:       appv1.TeamSpec{Subjects: []string{memberDN}},
+		}
+		Expect(k8s.Create(ctx, team)).To(Succeed())
+		Expect(k8s.Delete(ctx, team)).To(Succeed())
+
+		_, err := teams.Reconcile(ctx, requestFor(team))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(team), team)).To(Succeed())
+		Expect(team.Finalizers).To(ConsistOf(teamFinalizer.name))
+		Expect(directory.Writes()).To(BeEmpty())
+
+		delete(team.Annotations, appv1.ReconcileAnnotation)
+		Expect(k8s.Update(ctx, team)).To(Succeed())
+		_, err = teams.Reconcile(ctx, requestFor(team))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(apierrors.IsNotFound(k8s.Get(ctx, client.ObjectKeyFromObject(team), team))).To(BeTrue())
+		Expect(directory.Writes()).To(Equal([]string{"delete " + groupDN}))
+	})
+
+	It("keeps a Project and its projects until it leaves dry-run", func() {
+		existing := server.AddProject("infra/planned")
+
+		project := &appv1.Project{
+			ObjectMeta: metav1.ObjectMeta{Name: "planned", Namespace: "default", Annotations: dryRun(), Finalizers: []string{projectFinalizer.name}},
+			Spec:       appv1.ProjectSpec{Paths: []appv1.ProjectPath{{Path: "infra/planned"}}},
+		}
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+		Expect(k8s.Delete(ctx, project)).To(Succeed())
+
+		_, err := projects.Reconcile(ctx, requestFor(project))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(project), project)).To(Succeed())
+		Expect(project.Finalizers).To(ConsistOf(projectFinalizer.name))
+		Expect(server.Writes()).To(BeEmpty())
+
+		delete(project.Annotations, appv1.ReconcileAnnotation)
+		Expect(k8s.Update(ctx, project)).To(Succeed())
+		_, err = projects.Reconcile(ctx, requestFor(project))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(apierrors.IsNotFound(k8s.Get(ctx, client.ObjectKeyFromObject(project), project))).To(BeTrue())
+		Expect(server.Writes()).To(Equal([]string{fmt.Sprintf("POST /projects/%d/archive", existing.ID)}))
+	})
+})
@@ -0,0 +1,159 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/gitlab"
+)
+
+// projectInstanceIndex indexes Projects by the name of the GitlabInstances
+// their paths are managed on.
+const projectInstanceIndex = "spec.paths.instance"
+
+func indexProjectInstances(obj client.Object) []string {
+	var names []string
+
+	for _, projectPath := range obj.(*appv1.Project).Spec.Paths {
+		if projectPath.Instance != "" {
+			names = append(names, projectPath.Instance)
+		}
+	}
+
+	return names
+}
+
+// instanceSecretIndex indexes GitlabInstances by the Secrets they read.
+const instanceSecretIndex = "spec.secretRefs"
+
+func indexInstanceSecrets(obj client.Object) []string {
+	spec := obj.(*appv1.GitlabInstance).Spec
+
+	keys := []string{secretKey(spec.TokenSecretRef.Namespace, spec.TokenSecretRef.Name)}
+	if ref := spec.TLS.CASecretRef; ref != nil {
+		keys = append(keys, secretKey(ref.Namespace, ref.Name))
+	}
+
+	return keys
+}
+
+// gitlabFor returns the client of the GitlabInstance a path of a Project of
+// namespace is managed on, the operator one when it does not select any.
+// namespace is empty for the operator itself.
+func (r *ProjectReconciler) gitlabFor(ctx context.Context, namespace string, projectPath appv1.ProjectPath) (*gitlab.Client, error) {
+	name := projectPath.Instance
+	if name == "" {
+		return r.Gitlab, nil
+	}
+
+	instance := &appv1.GitlabInstance{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, instance); err != nil {
+		if errors.IsNotFound(err) {
+			r.instances.forget(name)
+		}
+		return nil, err
+	}
+
+	if !namespaceAllowed(instance.Spec.AllowedNamespaces, namespace) {
+		return nil, errNamespaceNotAllowed("GitlabInstance", name, namespace)
+	}
+
+	c, err := r.instanceClient(ctx, instance)
+	setClientReady(ctx, r.Client, instance, &instance.Status.Conditions, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// instanceClient returns the cached client of instance, built anew when the
+// GitlabInstance or the Secrets it reads changed.
+func (r *ProjectReconciler) instanceClient(ctx context.Context, instance *appv1.GitlabInstance) (*gitlab.Client, error) {
+	name, spec := instance.Name, instance.Spec
+
+	ref := spec.TokenSecretRef
+	secret, err := r.getSecret(ctx, ref.Namespace, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	token, err := secretValue(secret, ref.Key)
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{fmt.Sprint(instance.Generation), secret.ResourceVersion}
+	r.Credentials.Record("gitlabinstance/"+name, secretKey(ref.Namespace, ref.Name), secret.ResourceVersion)
+
+	options := gitlab.Options{
+		InsecureSkipVerify: spec.TLS.InsecureSkipVerify,
+		Visibility:         spec.DefaultVisibility,
+		RateLimit:          r.GitlabRateLimit,
+		PathCacheTTL:       r.GitlabPathCacheTTL,
+	}
+	if r.PendingDeletions != nil {
+		options.Schedule = r.PendingDeletions.For(name)
+	}
+	if ref := spec.TLS.CASecretRef; ref != nil {
+		secret, err := r.getSecret(ctx, ref.Namespace, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		options.CA = secret.Data[ref.Key]
+		versions = append(versions, secret.ResourceVersion)
+	}
+
+	return r.instances.load(name, cacheVersion(versions...), func() (*gitlab.Client, error) {
+		c, err := gitlab.NewInstance(spec.URL, token, options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitlabInstance %s: %w", name, err)
+		}
+		return c, nil
+	})
+}
+
+// projectsUsing enqueues the Projects with a path on the given GitlabInstance.
+func (r *ProjectReconciler) projectsUsing(obj client.Object) []reconcile.Request {
+	projects := &appv1.ProjectList{}
+	if err := r.List(context.Background(), projects, client.MatchingFields{projectInstanceIndex: obj.GetName()}); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(projects.Items))
+	for _, project := range projects.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&project)})
+	}
+
+	return requests
+}
+
+// projectsUsingSecret enqueues the Projects with a path on a GitlabInstance
+// reading the given Secret.
+func (r *ProjectReconciler) projectsUsingSecret(obj client.Object) []reconcile.Request {
+	instances := &appv1.GitlabInstanceList{}
+	if err := r.List(context.Background(), instances, client.MatchingFields{instanceSecretIndex: secretKey(obj.GetNamespace(), obj.GetName())}); err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, instance := range instances.Items {
+		requests = append(requests, r.projectsUsing(&instance)...)
+	}
+
+	return requests
+}
+
+// getSecret returns the Secret namespace/name, see secretCache.
+func (r *ProjectReconciler) getSecret(ctx context.Context, namespace, name string) (*v1.Secret, error) {
+	return r.secrets.get(ctx, r.Client, r.APIReader, namespace, name)
+}
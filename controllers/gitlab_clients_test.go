@@ -0,0 +1,128 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/gitlab/gitlabtest"
+)
+
+var _ = Describe("GitlabInstances", func() {
+	var (
+		k8s      client.Client
+		server   *gitlabtest.Server
+		projects *ProjectReconciler
+	)
+
+	newInstance := func(allowed ...string) *appv1.GitlabInstance {
+		return &appv1.GitlabInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "corp"},
+			Spec: appv1.GitlabInstanceSpec{
+				URL:               server.URL,
+				TokenSecretRef:    appv1.SecretKeyReference{Namespace: "wellerman-system", Name: "gitlab-token", Key: "token"},
+				AllowedNamespaces: allowed,
+			},
+		}
+	}
+	newProject := func(namespace string) *appv1.Project {
+		return &appv1.Project{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "infra",
+				Namespace:  namespace,
+				Finalizers: []string{projectFinalizer.name},
+			},
+			Spec: appv1.ProjectSpec{Paths: []appv1.ProjectPath{{Instance: "corp", Path: "infra/app"}}},
+		}
+	}
+	conditionOf := func(obj client.Object, conditions *[]metav1.Condition, t string) *metav1.Condition {
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(obj), obj)).To(Succeed())
+		return meta.FindStatusCondition(*conditions, t)
+	}
+
+	BeforeEach(func() {
+		server = gitlabtest.NewServer()
+		DeferCleanup(server.Close)
+
+		k8s = newFakeClient(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "wellerman-system", Name: "gitlab-token"},
+			Data:       map[string][]byte{"token": []byte("token")},
+		})
+		projects = &ProjectReconciler{Client: k8s, Scheme: scheme.Scheme, Recorder: newRecorder()}
+	})
+
+	It("records on the GitlabInstance whether a client could be built from it", func() {
+		instance := newInstance()
+		Expect(k8s.Create(ctx, instance)).To(Succeed())
+
+		_, err := projects.gitlabFor(ctx, "default", appv1.ProjectPath{Instance: "corp"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conditionOf(instance, &instance.Status.Conditions, conditionReady).Reason).To(Equal("ClientReady"))
+	})
+
+	It("refuses Projects of namespaces the GitlabInstance does not allow", func() {
+		Expect(k8s.Create(ctx, newInstance("platform"))).To(Succeed())
+
+		project := newProject("default")
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+
+		_, err := projects.Reconcile(ctx, requestFor(project))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conditionOf(project, &project.Status.Conditions, conditionStalled)).NotTo(BeNil())
+		Expect(conditionOf(project, &project.Status.Conditions, conditionDegraded).Status).To(Equal(metav1.ConditionTrue))
+		Expect(server.Writes()).To(BeEmpty())
+
+		_, err = projects.gitlabFor(ctx, "platform", appv1.ProjectPath{Instance: "corp"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("retries a Project with backoff while one of its paths may still sync", func() {
+		Expect(k8s.Create(ctx, newInstance("platform"))).To(Succeed())
+
+		project := newProject("default")
+		project.Spec.Paths = append(project.Spec.Paths, appv1.ProjectPath{Instance: "missing", Path: "infra/app"})
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+
+		_, err := projects.Reconcile(ctx, requestFor(project))
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "got %v", err)
+		Expect(conditionOf(project, &project.Status.Conditions, conditionStalled)).To(BeNil())
+	})
+
+	It("keeps a deleted Project whose GitlabInstance is missing until its deletion is confirmed", func() {
+		project := newProject("default")
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+		Expect(k8s.Delete(ctx, project)).To(Succeed())
+
+		_, err := projects.Reconcile(ctx, requestFor(project))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conditionOf(project, &project.Status.Conditions, conditionSynced).Reason).To(Equal("InstanceUnavailable"))
+		Expect(project.Finalizers).To(ConsistOf(projectFinalizer.name))
+
+		project.Annotations = map[string]string{appv1.ConfirmDeletionAnnotation: "true"}
+		Expect(k8s.Update(ctx, project)).To(Succeed())
+
+		_, err = projects.Reconcile(ctx, requestFor(project))
+		Expect(err).NotTo(HaveOccurred())
+		err = k8s.Get(ctx, client.ObjectKeyFromObject(project), project)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "got %v", err)
+	})
+
+	It("compares paths by instance and path", func() {
+		a := []appv1.ProjectPath{{Path: "infra/app"}, {Instance: "corp", Path: "infra/app"}}
+		b := []appv1.ProjectPath{{Path: "infra/app"}}
+
+		Expect(projectPathDifference(a, b)).To(Equal([]appv1.ProjectPath{{Instance: "corp", Path: "infra/app"}}))
+		Expect(projectPathDifference(b, a)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,233 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+)
+
+// resourceKey identifies a resource in the metrics.
+type resourceKey struct {
+	kind, namespace, name string
+}
+
+// syncTimes holds the time of the last successful sync of every resource, for
+// the collector to report.
+var syncTimes = struct {
+	sync.Mutex
+	times map[resourceKey]time.Time
+}{times: map[resourceKey]time.Time{}}
+
+// kindOf returns the kind of the resources reconciled by wellerman, which are
+// not guaranteed to have their TypeMeta set.
+func kindOf(obj client.Object) string {
+	switch obj.(type) {
+	case *appv1.ClusterTeam:
+		return "ClusterTeam"
+	case *appv1.Team:
+		return "Team"
+	case *appv1.Project:
+		return "Project"
+	}
+	return obj.GetObjectKind().GroupVersionKind().Kind
+}
+
+// markSynced records that obj was just synced successfully.
+func markSynced(obj client.Object) {
+	syncTimes.Lock()
+	defer syncTimes.Unlock()
+
+	syncTimes.times[resourceKey{kindOf(obj), obj.GetNamespace(), obj.GetName()}] = time.Now()
+}
+
+// forgetSynced drops the last sync time of a resource that no longer exists.
+func forgetSynced(kind, namespace, name string) {
+	syncTimes.Lock()
+	defer syncTimes.Unlock()
+
+	delete(syncTimes.times, resourceKey{kind, namespace, name})
+}
+
+// collectTimeout bounds the listing of the resources on each scrape.
+const collectTimeout = 5 * time.Second
+
+var (
+	projectsDesc = prometheus.NewDesc(
+		"wellerman_projects",
+		"Number of Projects, by status and reason of their Ready condition.",
+		[]string{"ready", "reason"}, nil,
+	)
+	projectPathsDesc = prometheus.NewDesc(
+		"wellerman_project_paths",
+		"Number of paths managed by Projects, by instance and state.",
+		[]string{"instance", "state"}, nil,
+	)
+	teamsDesc = prometheus.NewDesc(
+		"wellerman_teams",
+		"Number of Teams and ClusterTeams, by kind and by status and reason of their Ready condition.",
+		[]string{"kind", "ready", "reason"}, nil,
+	)
+	teamMembershipsDesc = prometheus.NewDesc(
+		"wellerman_team_memberships",
+		"Number of members in the groups of Teams and ClusterTeams, by kind.",
+		[]string{"kind"}, nil,
+	)
+	oldestSyncDesc = prometheus.NewDesc(
+		"wellerman_oldest_sync_timestamp_seconds",
+		"Time of the last successful sync of the resource synced the longest ago, by kind. time() minus it is the longest time a resource went without a sync.",
+		[]string{"kind"}, nil,
+	)
+
+	// The series of each resource, only reported with per resource metrics.
+	lastSyncDesc = prometheus.NewDesc(
+		"wellerman_last_sync_timestamp_seconds",
+		"Time of the last successful sync of a resource, time() minus it is the time since then.",
+		[]string{"kind", "namespace", "name"}, nil,
+	)
+	teamMembersDesc = prometheus.NewDesc(
+		"wellerman_team_members",
+		"Number of members in the group of a Team or ClusterTeam.",
+		[]string{"kind", "namespace", "name"}, nil,
+	)
+)
+
+// resourceCollector reports the resources managed by wellerman as they are in
+// the cache, when scraped. Series are aggregated by kind, unless perResource
+// adds one series per resource as well.
+type resourceCollector struct {
+	reader      client.Reader
+	perResource bool
+}
+
+// registerResourceCollector registers the collector of the resources read
+// with reader, once.
+func registerResourceCollector(reader client.Reader, perResource bool) error {
+	err := metrics.Registry.Register(&resourceCollector{reader: reader, perResource: perResource})
+	if are := (prometheus.AlreadyRegisteredError{}); errors.As(err, &are) {
+		return nil
+	}
+	return err
+}
+
+func (c *resourceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- projectsDesc
+	ch <- projectPathsDesc
+	ch <- teamsDesc
+	ch <- teamMembershipsDesc
+	ch <- oldestSyncDesc
+	ch <- lastSyncDesc
+	ch <- teamMembersDesc
+}
+
+func (c *resourceCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	logger := log.FromContext(ctx).WithName("metrics")
+
+	projects := &appv1.ProjectList{}
+	if err := c.reader.List(ctx, projects); err != nil {
+		logger.Error(err, "Failed to list Projects.")
+	}
+
+	byReady := map[[2]string]int{}
+	byState := map[[2]string]int{}
+	for _, project := range projects.Items {
+		byReady[readyOf(project.Status.Conditions)]++
+		for _, path := range project.Status.Paths {
+			byState[[2]string{path.Instance, string(path.State)}]++
+		}
+	}
+	for labels, count := range byReady {
+		ch <- prometheus.MustNewConstMetric(projectsDesc, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+	}
+	for labels, count := range byState {
+		ch <- prometheus.MustNewConstMetric(projectPathsDesc, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+	}
+
+	var teams []appv1.TeamObject
+
+	teamList := &appv1.TeamList{}
+	if err := c.reader.List(ctx, teamList); err != nil {
+		logger.Error(err, "Failed to list Teams.")
+	}
+	for i := range teamList.Items {
+		teams = append(teams, &teamList.Items[i])
+	}
+
+	clusterTeamList := &appv1.ClusterTeamList{}
+	if err := c.reader.List(ctx, clusterTeamList); err != nil {
+		logger.Error(err, "Failed to list ClusterTeams.")
+	}
+	for i := range clusterTeamList.Items {
+		teams = append(teams, &clusterTeamList.Items[i])
+	}
+
+	byKind := map[[3]string]int{}
+	memberships := map[string]int{}
+	for _, team := range teams {
+		kind, status := kindOf(team), team.GetStatus()
+		ready := readyOf(status.Conditions)
+		byKind[[3]string{kind, ready[0], ready[1]}]++
+		memberships[kind] += len(status.Members)
+		if c.perResource {
+			ch <- prometheus.MustNewConstMetric(teamMembersDesc, prometheus.GaugeValue, float64(len(status.Members)), kind, team.GetNamespace(), team.GetName())
+		}
+	}
+	for labels, count := range byKind {
+		ch <- prometheus.MustNewConstMetric(teamsDesc, prometheus.GaugeValue, float64(count), labels[0], labels[1], labels[2])
+	}
+	for kind, count := range memberships {
+		ch <- prometheus.MustNewConstMetric(teamMembershipsDesc, prometheus.GaugeValue, float64(count), kind)
+	}
+
+	c.collectSyncTimes(ch)
+}
+
+// collectSyncTimes reports the oldest last sync of each kind, and the last
+// sync of every resource with per resource metrics.
+func (c *resourceCollector) collectSyncTimes(ch chan<- prometheus.Metric) {
+	syncTimes.Lock()
+	defer syncTimes.Unlock()
+
+	oldest := map[string]time.Time{}
+	for key, synced := range syncTimes.times {
+		if previous, ok := oldest[key.kind]; !ok || synced.Before(previous) {
+			oldest[key.kind] = synced
+		}
+		if c.perResource {
+			ch <- prometheus.MustNewConstMetric(lastSyncDesc, prometheus.GaugeValue, unixSeconds(synced), key.kind, key.namespace, key.name)
+		}
+	}
+	for kind, synced := range oldest {
+		ch <- prometheus.MustNewConstMetric(oldestSyncDesc, prometheus.GaugeValue, unixSeconds(synced), kind)
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// readyOf returns the status and reason of the Ready condition, Unknown and
+// Pending when it is not set yet.
+func readyOf(conditions []metav1.Condition) [2]string {
+	ready := meta.FindStatusCondition(conditions, conditionReady)
+	if ready == nil {
+		return [2]string{"Unknown", "Pending"}
+	}
+	return [2]string{string(ready.Status), ready.Reason}
+}
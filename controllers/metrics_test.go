@@ -0,0 +1,111 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+)
+
+var _ = Describe("resourceCollector", func() {
+	var saved map[resourceKey]time.Time
+
+	BeforeEach(func() {
+		syncTimes.Lock()
+		saved, syncTimes.times = syncTimes.times, map[resourceKey]time.Time{}
+		syncTimes.Unlock()
+	})
+
+	AfterEach(func() {
+		syncTimes.Lock()
+		syncTimes.times = saved
+		syncTimes.Unlock()
+	})
+
+	newTeam := func(name string, members ...string) *appv1.Team {
+		return &appv1.Team{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     appv1.TeamStatus{Members: members},
+		}
+	}
+
+	// gather returns the value of the series of each metric, keyed by their
+	// label values.
+	gather := func(perResource bool) map[string]map[string]float64 {
+		registry := prometheus.NewRegistry()
+		Expect(registry.Register(&resourceCollector{
+			reader:      newFakeClient(newTeam("sre", "uid=jdoe", "uid=asmith"), newTeam("dev", "uid=bking")),
+			perResource: perResource,
+		})).To(Succeed())
+
+		families, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+
+		series := map[string]map[string]float64{}
+		for _, family := range families {
+			series[family.GetName()] = map[string]float64{}
+			for _, metric := range family.GetMetric() {
+				series[family.GetName()][labelValues(metric)] = metric.GetGauge().GetValue()
+			}
+		}
+		return series
+	}
+
+	It("aggregates the series by kind", func() {
+		old := time.Now().Add(-time.Hour)
+		markSynced(newTeam("sre"))
+		markSynced(newTeam("dev"))
+		syncTimes.times[resourceKey{"Team", "default", "dev"}] = old
+
+		series := gather(false)
+
+		Expect(series["wellerman_team_memberships"]).To(Equal(map[string]float64{"Team": 3}))
+		Expect(series["wellerman_teams"]).To(Equal(map[string]float64{"Team/Unknown/Pending": 2}))
+		Expect(series["wellerman_oldest_sync_timestamp_seconds"]).To(Equal(map[string]float64{"Team": unixSeconds(old)}))
+		Expect(series).NotTo(HaveKey("wellerman_team_members"))
+		Expect(series).NotTo(HaveKey("wellerman_last_sync_timestamp_seconds"))
+	})
+
+	It("reports a series per resource when asked to", func() {
+		markSynced(newTeam("sre"))
+
+		series := gather(true)
+
+		Expect(series["wellerman_team_members"]).To(Equal(map[string]float64{"Team/sre/default": 2, "Team/dev/default": 1}))
+		Expect(series["wellerman_last_sync_timestamp_seconds"]).To(HaveLen(1))
+		Expect(series["wellerman_last_sync_timestamp_seconds"]).To(HaveKey("Team/sre/default"))
+		Expect(series["wellerman_team_memberships"]).To(Equal(map[string]float64{"Team": 3}))
+	})
+
+	It("forgets the resources deleted", func() {
+		markSynced(newTeam("sre"))
+		forgetSynced("Team", "default", "sre")
+
+		series := gather(true)
+
+		Expect(series).NotTo(HaveKey("wellerman_last_sync_timestamp_seconds"))
+		Expect(series).NotTo(HaveKey("wellerman_oldest_sync_timestamp_seconds"))
+	})
+})
+
+// labelValues joins the label values of metric, in the order of their names.
+func labelValues(metric *dto.Metric) string {
+	values := ""
+	for i, label := range metric.GetLabel() {
+		if i > 0 {
+			values += "/"
+		}
+		values += label.GetValue()
+	}
+	return values
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/vbouchaud/wellerman/internal/gitlab"
+)
+
+// PendingDeletions records in a ConfigMap the GitLab projects scheduled for
+// deletion, on the operator instance and every GitlabInstance. Only those are
+// purged, whatever the topics set on GitLab.
+type PendingDeletions struct {
+	client client.Client
+	reader client.Reader
+	key    types.NamespacedName
+}
+
+// NewPendingDeletions records the projects scheduled for deletion in the
+// ConfigMap at key, created when missing. It is read through reader, which
+// must not be a cache for the ConfigMap to be read anew every time.
+func NewPendingDeletions(c client.Client, reader client.Reader, key types.NamespacedName) *PendingDeletions {
+	return &PendingDeletions{client: c, reader: reader, key: key}
+}
+
+// For returns the schedule of the GitlabInstance of the given name, "" for
+// the operator instance.
+func (p *PendingDeletions) For(instance string) gitlab.Schedule {
+	return &instanceDeletions{PendingDeletions: p, prefix: instance + "_"}
+}
+
+// instanceDeletions are the entries of the ConfigMap for one instance, keyed
+// INSTANCE_ID. Kubernetes names cannot hold an underscore, so the entries of
+// two instances never mix.
+type instanceDeletions struct {
+	*PendingDeletions
+	prefix string
+}
+
+func (d *instanceDeletions) Add(ctx context.Context, id int, deadline time.Time) error {
+	value := deadline.UTC().Format(time.RFC3339)
+	return d.update(ctx, func(data map[string]string) bool {
+		key := d.prefix + strconv.Itoa(id)
+		if data[key] == value {
+			return false
+		}
+		data[key] = value
+		return true
+	})
+}
+
+func (d *instanceDeletions) Remove(ctx context.Context, id int) error {
+	return d.update(ctx, func(data map[string]string) bool {
+		key := d.prefix + strconv.Itoa(id)
+		if _, ok := data[key]; !ok {
+			return false
+		}
+		delete(data, key)
+		return true
+	})
+}
+
+func (d *instanceDeletions) Deadlines(ctx context.Context) (map[int]time.Time, error) {
+	configMap := &v1.ConfigMap{}
+	if err := d.reader.Get(ctx, d.key, configMap); errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	deadlines := map[int]time.Time{}
+	for key, value := range configMap.Data {
+		if !strings.HasPrefix(key, d.prefix) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(key, d.prefix))
+		if err != nil {
+			continue
+		}
+		deadline, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deadline %q of %s in ConfigMap %s: %w", value, key, d.key, err)
+		}
+		deadlines[id] = deadline
+	}
+
+	return deadlines, nil
+}
+
+// update applies mutate to the data of the ConfigMap, created when missing,
+// retrying on conflicts with concurrent updates. Nothing is written when
+// mutate reports no change.
+func (p *PendingDeletions) update(ctx context.Context, mutate func(map[string]string) bool) error {
+	return retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+	}, func() error {
+		configMap := &v1.ConfigMap{}
+		err := p.reader.Get(ctx, p.key, configMap)
+		if errors.IsNotFound(err) {
+			configMap = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: p.key.Namespace, Name: p.key.Name},
+				Data:       map[string]string{},
+			}
+			if !mutate(configMap.Data) {
+				return nil
+			}
+			return p.client.Create(ctx, configMap)
+		} else if err != nil {
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		if !mutate(configMap.Data) {
+			return nil
+		}
+		return p.client.Update(ctx, configMap)
+	})
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("PendingDeletions", func() {
+	var (
+		k8s     client.Client
+		key     types.NamespacedName
+		pending *PendingDeletions
+	)
+
+	BeforeEach(func() {
+		k8s = newFakeClient()
+		key = types.NamespacedName{Namespace: "wellerman-system", Name: "wellerman-pending-deletions"}
+		pending = NewPendingDeletions(k8s, k8s, key)
+	})
+
+	It("records deadlines in a ConfigMap created when missing", func() {
+		deadline := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+		Expect(pending.For("").Add(ctx, 42, deadline)).To(Succeed())
+		Expect(pending.For("gitlab-com").Add(ctx, 42, deadline.Add(time.Hour))).To(Succeed())
+
+		configMap := &v1.ConfigMap{}
+		Expect(k8s.Get(ctx, key, configMap)).To(Succeed())
+		Expect(configMap.Data).To(Equal(map[string]string{
+			"_42":           "2023-06-01T12:00:00Z",
+			"gitlab-com_42": "2023-06-01T13:00:00Z",
+		}))
+
+		Expect(pending.For("").Deadlines(ctx)).To(Equal(map[int]time.Time{42: deadline}))
+		Expect(pending.For("gitlab-com").Deadlines(ctx)).To(Equal(map[int]time.Time{42: deadline.Add(time.Hour)}))
+		Expect(pending.For("gitlab").Deadlines(ctx)).To(BeEmpty())
+	})
+
+	It("only forgets the deadlines of its instance", func() {
+		deadline := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+		Expect(pending.For("").Add(ctx, 42, deadline)).To(Succeed())
+		Expect(pending.For("gitlab-com").Add(ctx, 42, deadline)).To(Succeed())
+
+		Expect(pending.For("gitlab-com").Remove(ctx, 42)).To(Succeed())
+		Expect(pending.For("gitlab-com").Remove(ctx, 42)).To(Succeed())
+
+		Expect(pending.For("").Deadlines(ctx)).To(HaveKey(42))
+		Expect(pending.For("gitlab-com").Deadlines(ctx)).To(BeEmpty())
+	})
+
+	It("does not create the ConfigMap to forget a deadline", func() {
+		Expect(pending.For("").Remove(ctx, 42)).To(Succeed())
+		Expect(pending.For("").Deadlines(ctx)).To(BeEmpty())
+
+		configMaps := &v1.ConfigMapList{}
+		Expect(k8s.List(ctx, configMaps)).To(Succeed())
+		Expect(configMaps.Items).To(BeEmpty())
+	})
+})
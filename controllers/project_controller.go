@@ -6,39 +6,105 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/backend"
+	"github.com/vbouchaud/wellerman/internal/credentials"
 	"github.com/vbouchaud/wellerman/internal/gitlab"
 )
 
 // ProjectReconciler reconciles a Project object
 type ProjectReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Gitlab *gitlab.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Gitlab   *gitlab.Client
+
+	// Mode is the mode Projects are reconciled in when they do not set one
+	// with the reconcile annotation.
+	Mode appv1.ReconcileMode
+
+	// AllowHardDelete lets the projects of paths with the Delete and
+	// DeleteAfter policies be deleted, they are only archived otherwise.
+	AllowHardDelete bool
+
+	// DeletionGracePeriod is how long projects with the Delete policy are
+	// kept archived before being deleted.
+	DeletionGracePeriod time.Duration
+
+	// Resync sets how often projects are checked for changes made outside of
+	// wellerman.
+	Resync ResyncOptions
+
+	// MaxConcurrentReconciles is how many Projects are reconciled at once.
+	MaxConcurrentReconciles int
+
+	// PerResourceMetrics reports metrics by resource, along with those by
+	// kind. It adds series for every resource.
+	PerResourceMetrics bool
+
+	// GitlabRateLimit bounds the requests sent to each GitlabInstance.
+	GitlabRateLimit gitlab.RateLimitOptions
+
+	// GitlabPathCacheTTL is how long the ids of the groups and projects of
+	// each GitlabInstance are remembered for.
+	GitlabPathCacheTTL time.Duration
+
+	// Hooks, when set, has the Projects the GitLab system hooks are about
+	// reconciled.
+	Hooks *HookReceiver
+
+	// PendingDeletions records the projects scheduled for deletion on the
+	// GitlabInstances, the only ones purged. Held in memory when nil.
+	PendingDeletions *PendingDeletions
+
+	// Credentials records the Secrets read for GitlabInstances.
+	Credentials *credentials.Registry
+
+	// APIReader reads the data of the Secrets of GitlabInstances, which the
+	// manager only caches the metadata of.
+	APIReader client.Reader
+
+	instances clientCache[*gitlab.Client]
+	secrets   secretCache
 }
 
-const projectFinalizer = "app.heidrun.bouchaud.org/project-finalizer"
+var projectFinalizer = finalizer{
+	name:   "app.wellerman.bouchaud.org/project-finalizer",
+	legacy: "app.heidrun.bouchaud.org/project-finalizer",
+}
 
+// projectPathDifference returns the paths of a that are not in b, on the same
+// instance.
 func projectPathDifference(a, b []appv1.ProjectPath) (diff []appv1.ProjectPath) {
-	m := make(map[string]bool)
+	m := make(map[[2]string]bool)
 
 	for _, item := range b {
-		m[item.Path] = true
+		m[[2]string{item.Instance, item.Path}] = true
 	}
 
 	for _, item := range a {
-		if _, ok := m[item.Path]; !ok {
+		if _, ok := m[[2]string{item.Instance, item.Path}]; !ok {
 			diff = append(diff, item)
 		}
 	}
@@ -49,10 +115,21 @@ func projectPathDifference(a, b []appv1.ProjectPath) (diff []appv1.ProjectPath)
 //+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=projects,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=projects/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=projects/finalizers,verbs=update
+//+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=gitlabinstances,verbs=get;list;watch
+//+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=gitlabinstances/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;create;update
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 func (r *ProjectReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return traced(ctx, "Project", req, r.reconcileProject)
+}
+
+// reconcileProject holds the reconciliation of a Project, in the span started
+// by Reconcile.
+func (r *ProjectReconciler) reconcileProject(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
 	logger.Info("Reconciling Project.")
@@ -63,26 +140,75 @@ func (r *ProjectReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	if err != nil {
 		if errors.IsNotFound(err) {
 			logger.Info("Project resource not found. Ignoring since object must be deleted.")
+			forgetSynced(kindOf(project), req.Namespace, req.Name)
 			return ctrl.Result{}, nil
 		}
 		logger.Error(err, "Failed to get Project.")
 		return ctrl.Result{}, err
 	}
 
+	mode := appv1.ModeOf(project.Annotations, r.Mode)
+	if mode == appv1.ReconcilePaused {
+		logger.Info("Project reconciliation paused.", "project", project.Name)
+		original := project.Status.DeepCopy()
+		addCondition(logger, &project.Status.Conditions, project.Generation, conditionSynced, metav1.ConditionUnknown, "Paused", fmt.Sprintf("Reconciliation is paused, see the %s annotation.", appv1.ReconcileAnnotation))
+		return ctrl.Result{}, r.updateProjectStatus(ctx, project, original)
+	}
+	dryRun := mode == appv1.ReconcileDryRun
+
+	if projectFinalizer.migrate(project) {
+		if err = r.Update(ctx, project); err != nil {
+			logger.Error(err, "Failed to migrate finalizer.", "project", project.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
 	// Project deletion
 	isProjectMarkedToBeDeleted := project.GetDeletionTimestamp() != nil
 	if isProjectMarkedToBeDeleted {
-		if controllerutil.ContainsFinalizer(project, projectFinalizer) {
+		if projectFinalizer.heldBy(project) {
+			confirmed := project.Annotations[appv1.ConfirmDeletionAnnotation] == "true"
 			for _, projectPath := range project.Spec.Paths {
 				if !projectPath.External {
-					if err, _ /* changed */ := r.Gitlab.DeleteProject(projectPath); err != nil {
-						logger.Error(err, "Error while removing gitlab project.", "project", project.Name, "project-path", projectPath.Name)
+					instance, err := r.gitlabFor(ctx, project.Namespace, projectPath)
+					if (errors.IsNotFound(err) || backend.IsTerminal(err)) && !confirmed {
+						logger.Info("GitlabInstance unavailable, keeping the Project until it is back or the deletion is confirmed.", "project", project.Name, "project-path", projectPath.Name)
+						message := fmt.Sprintf("The project %s cannot be deleted: %s. Fix the GitlabInstance, or set the %s annotation to \"true\" to delete the Project and leave the project in place.", projectPath.Path, err, appv1.ConfirmDeletionAnnotation)
+						original := project.Status.DeepCopy()
+						addCondition(logger, &project.Status.Conditions, project.Generation, conditionSynced, metav1.ConditionFalse, "InstanceUnavailable", message)
+						r.Recorder.Event(project, v1.EventTypeWarning, "InstanceUnavailable", message)
+						return ctrl.Result{}, r.updateProjectStatus(ctx, project, original)
+					}
+					if errors.IsNotFound(err) || backend.IsTerminal(err) {
+						logger.Info("GitlabInstance unavailable, deletion confirmed, leaving the gitlab project in place.", "project", project.Name, "project-path", projectPath.Name)
+						r.Recorder.Event(project, v1.EventTypeWarning, "PathLeftInPlace", fmt.Sprintf("The project %s was left in place: %s.", projectPath.Path, err))
+						continue
+					}
+					if err != nil {
+						logger.Error(err, "Failed to get GitlabInstance client.", "project", project.Name, "project-path", projectPath.Name)
 						return ctrl.Result{}, err
 					}
+					err, changes := r.deleteProject(ctx, instance, projectPath, dryRun)
+					r.recordChanges(project, changes, dryRun)
+					if err != nil {
+						logger.Error(err, "Error while removing gitlab project.", "project", project.Name, "project-path", projectPath.Name)
+						r.Recorder.Event(project, v1.EventTypeWarning, "PathDeletionFailed", fmt.Sprintf("%s: %s", projectPath.Path, err))
+						return retryAfterError(r.Resync, err)
+					}
 				}
 			}
 
-			controllerutil.RemoveFinalizer(project, projectFinalizer)
+			// In dry-run, the projects are left in place and so is the
+			// finalizer, for the deletion to be done once the Project leaves
+			// dry-run.
+			if dryRun {
+				logger.Info("Project deletion planned, keeping the finalizer until it leaves dry-run.", "project", project.Name)
+				original := project.Status.DeepCopy()
+				addCondition(logger, &project.Status.Conditions, project.Generation, conditionSynced, metav1.ConditionFalse, "DryRun", fmt.Sprintf("Planned: the paths are deleted once the Project leaves dry-run, see the %s annotation.", appv1.ReconcileAnnotation))
+				return ctrl.Result{}, r.updateProjectStatus(ctx, project, original)
+			}
+
+			projectFinalizer.remove(project)
 			if err = r.Update(ctx, project); err != nil {
 				logger.Error(err, "Failed to remove finalizer.", "project", project.Name)
 				return ctrl.Result{}, err
@@ -92,60 +218,289 @@ func (r *ProjectReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 
 	// Project Initialization
-	if !controllerutil.ContainsFinalizer(project, projectFinalizer) {
-		controllerutil.AddFinalizer(project, projectFinalizer)
-		addCondition(logger, &project.Status.Conditions, conditionConfigured, metav1.ConditionFalse)
+	if !projectFinalizer.heldBy(project) {
+		controllerutil.AddFinalizer(project, projectFinalizer.name)
 		if err = r.Update(ctx, project); err != nil {
-			logger.Error(err, "Failed to initialize Project status.", "project", project.Name)
+			logger.Error(err, "Failed to add finalizer.", "project", project.Name)
 			return ctrl.Result{}, err
 		}
 	}
 
-	// Project update
-	changed := false
+	original := project.Status.DeepCopy()
+	generation := project.Generation
 
+	// Project update
 	var lastAppliedConfig appv1.Project
 	// lastAppliedConfig should only be used to detect gitlab projects that the operator should delete. Might not be enough.
 	if err = json.Unmarshal([]byte(project.GetObjectMeta().GetAnnotations()[v1.LastAppliedConfigAnnotation]), &lastAppliedConfig); err == nil {
-		for _, projectPath := range projectPathDifference(project.Spec.Paths, lastAppliedConfig.Spec.Paths) {
+		for _, projectPath := range projectPathDifference(lastAppliedConfig.Spec.Paths, project.Spec.Paths) {
+			// Removed paths are computed anew on every reconcile. Retaining one
+			// writes nothing, it is only reported while the path is still in
+			// the status, the first time.
+			if projectPath.GetDeletionPolicy() == appv1.DeletionRetain && !pathRecorded(original.Paths, projectPath) {
+				continue
+			}
 			if !projectPath.External {
-				err, pathChanged := r.Gitlab.DeleteProject(projectPath)
+				instance, err := r.gitlabFor(ctx, project.Namespace, projectPath)
+				if errors.IsNotFound(err) || backend.IsTerminal(err) {
+					logger.Info("GitlabInstance unavailable, leaving the gitlab project in place.", "project", project.Name, "project-path", projectPath.Name)
+					r.Recorder.Event(project, v1.EventTypeWarning, "PathLeftInPlace", fmt.Sprintf("The project %s was removed from the Project but left in place: %s.", projectPath.Path, err))
+					continue
+				}
+				if err != nil {
+					logger.Error(err, "Failed to get GitlabInstance client.", "project", project.Name, "project-path", projectPath.Name)
+					return r.failProject(ctx, project, original, "InstanceUnavailable", err)
+				}
+				err, changes := r.deleteProject(ctx, instance, projectPath, dryRun)
+				r.recordChanges(project, changes, dryRun)
 				if err != nil {
 					logger.Error(err, "Error while removing gitlab project.", "project", project.Name, "project-path", projectPath.Name)
-					return ctrl.Result{}, err
+					return r.failProject(ctx, project, original, "PathDeletionFailed", err)
 				}
-				changed = pathChanged || changed
 			}
 		}
 	}
 
+	var (
+		paths    []appv1.ProjectPathStatus
+		failed   []string
+		planned  []string
+		drift    []string
+		managed  int
+		retryErr error
+	)
+
+	// Changes to a path that was already synced for this spec are drift, only
+	// reported when so configured.
+	applied := specApplied(original.Conditions, generation)
+	reportOnly := dryRun || r.Resync.reportOnly()
+
 	for _, projectPath := range project.Spec.Paths {
-		if !projectPath.External {
-			var pathChanged bool
-			err, pathChanged = r.Gitlab.ReconcileProject(projectPath)
-			if err != nil {
-				logger.Error(err, "Failed to crupdate Project resource.", "project", project.Name, "project-path", projectPath.Name)
-				return ctrl.Result{}, err
+		pathStatus := appv1.ProjectPathStatus{Path: projectPath.Path, Instance: projectPath.Instance}
+
+		if projectPath.External {
+			pathStatus.State = appv1.PathExternal
+			paths = append(paths, pathStatus)
+			continue
+		}
+		managed++
+
+		drifting := applied && pathSynced(original.Paths, projectPath)
+
+		changes, err := r.reconcilePath(ctx, project, projectPath, &pathStatus, dryRun || (drifting && reportOnly))
+		var messages []string
+		for _, change := range changes {
+			messages = append(messages, change.Message)
+		}
+		if drifting {
+			drift = append(drift, messages...)
+		}
+		if err == nil && len(messages) > 0 {
+			switch {
+			case dryRun:
+				pathStatus.State = appv1.PathPlanned
+				pathStatus.Message = "Planned: " + strings.Join(messages, "; ")
+				planned = append(planned, messages...)
+			case drifting && reportOnly:
+				pathStatus.State = appv1.PathDrifted
+				pathStatus.Message = "The project differs from the spec, see the Drifted condition."
 			}
+		}
+		if err != nil {
+			logger.Error(err, "Failed to crupdate Project resource.", "project", project.Name, "project-path", projectPath.Name)
+			r.Recorder.Event(project, v1.EventTypeWarning, "PathSyncFailed", fmt.Sprintf("%s: %s", projectPath.Path, err))
+			failed = append(failed, projectPath.Path)
+			// The reconciliation is retried after the first error that is
+			// not terminal, if any.
+			if retryErr == nil || (backend.IsTerminal(retryErr) && !backend.IsTerminal(err)) {
+				retryErr = err
+			}
+		}
+		paths = append(paths, pathStatus)
+	}
+	project.Status.Paths = paths
+
+	switch {
+	case len(failed) == 0 && len(planned) > 0:
+		addCondition(logger, &project.Status.Conditions, generation, conditionSynced, metav1.ConditionFalse, "DryRun", "Planned: "+strings.Join(planned, "; "))
+		addCondition(logger, &project.Status.Conditions, generation, conditionDegraded, metav1.ConditionFalse, "DryRun", "")
+	case len(failed) == 0:
+		addCondition(logger, &project.Status.Conditions, generation, conditionSynced, metav1.ConditionTrue, "Synced", "")
+		addCondition(logger, &project.Status.Conditions, generation, conditionDegraded, metav1.ConditionFalse, "Synced", "")
+	case len(failed) == managed:
+		addCondition(logger, &project.Status.Conditions, generation, conditionSynced, metav1.ConditionFalse, "PathSyncFailed", fmt.Sprintf("No path could be synced: %s.", strings.Join(failed, ", ")))
+		addCondition(logger, &project.Status.Conditions, generation, conditionDegraded, metav1.ConditionTrue, "PathSyncFailed", fmt.Sprintf("No path could be synced: %s.", strings.Join(failed, ", ")))
+	default:
+		addCondition(logger, &project.Status.Conditions, generation, conditionSynced, metav1.ConditionTrue, "Synced", "")
+		addCondition(logger, &project.Status.Conditions, generation, conditionDegraded, metav1.ConditionTrue, "PathSyncFailed", fmt.Sprintf("Some paths could not be synced: %s.", strings.Join(failed, ", ")))
+	}
+
+	setDrifted(logger, &project.Status.Conditions, generation, drift, reportOnly)
+	setStalled(logger, &project.Status.Conditions, generation, retryErr)
+
+	if err = r.updateProjectStatus(ctx, project, original); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if retryErr != nil {
+		return retryAfterError(r.Resync, retryErr)
+	}
+	if len(failed) == 0 && len(planned) == 0 {
+		markSynced(project)
+	}
+
+	return r.Resync.requeue(ctrl.Result{}), nil
+}
+
+// deleteProject applies the deletion policy of a removed path, downgraded to
+// Archive when hard deletes are not allowed.
+func (r *ProjectReconciler) deleteProject(ctx context.Context, instance *gitlab.Client, projectPath appv1.ProjectPath, dryRun bool) (error, []gitlab.Change) {
+	policy, grace := projectPath.GetDeletionPolicy(), time.Duration(0)
+
+	switch {
+	case policy != appv1.DeletionDelete && policy != appv1.DeletionDeleteAfter:
+		// Retain and Archive have no grace period.
+	case !r.AllowHardDelete:
+		policy = appv1.DeletionArchive
+	case policy == appv1.DeletionDeleteAfter && projectPath.DeletionGracePeriod != nil:
+		grace = projectPath.DeletionGracePeriod.Duration
+	default:
+		grace = r.DeletionGracePeriod
+	}
 
-			changed = pathChanged || changed
+	return instance.DeleteProject(ctx, projectPath, policy, grace, dryRun)
+}
+
+// pathRecorded tells whether path is listed in statuses.
+func pathRecorded(statuses []appv1.ProjectPathStatus, path appv1.ProjectPath) bool {
+	for _, status := range statuses {
+		if status.Instance == path.Instance && status.Path == path.Path {
+			return true
 		}
 	}
+	return false
+}
 
-	if changed {
-		addCondition(logger, &project.Status.Conditions, conditionConfigured, metav1.ConditionTrue)
-		if err = r.Update(ctx, project); err != nil {
-			logger.Error(err, "Failed to update Project status.", "ldap-group", project.Name)
-			return ctrl.Result{}, err
+// pathSynced tells whether path was synced according to statuses, drifted
+// paths having been synced before.
+func pathSynced(statuses []appv1.ProjectPathStatus, path appv1.ProjectPath) bool {
+	for _, status := range statuses {
+		if status.Instance == path.Instance && status.Path == path.Path {
+			return status.State == appv1.PathSynced || status.State == appv1.PathDrifted
+		}
+	}
+	return false
+}
+
+// reconcilePath syncs a path on its GitLab instance and reports it in
+// pathStatus. With dryRun, the changes are only reported.
+func (r *ProjectReconciler) reconcilePath(ctx context.Context, project *appv1.Project, projectPath appv1.ProjectPath, pathStatus *appv1.ProjectPathStatus, dryRun bool) ([]gitlab.Change, error) {
+	pathStatus.State = appv1.PathFailed
+
+	instance, err := r.gitlabFor(ctx, project.Namespace, projectPath)
+	if err != nil {
+		pathStatus.Message = fmt.Sprintf("GitlabInstance unavailable: %s", err)
+		return nil, err
+	}
+
+	info, err, changes := instance.ReconcileProject(ctx, projectPath, dryRun)
+	r.recordChanges(project, changes, dryRun)
+	if err != nil {
+		pathStatus.Message = err.Error()
+		return changes, err
+	}
+
+	pathStatus.State = appv1.PathSynced
+	pathStatus.ProjectID = info.ID
+	pathStatus.WebURL = info.WebURL
+
+	return changes, nil
+}
+
+// recordChanges reports the writes done on GitLab as events on project, or
+// the writes that would have been done with dryRun.
+func (r *ProjectReconciler) recordChanges(project *appv1.Project, changes []gitlab.Change, dryRun bool) {
+	for _, change := range changes {
+		if dryRun {
+			r.Recorder.Event(project, v1.EventTypeWarning, change.Reason, "[dry-run] "+change.Message)
+		} else {
+			r.Recorder.Event(project, v1.EventTypeNormal, change.Reason, change.Message)
 		}
 	}
+}
+
+// failProject records why a Project could not be synced, and tells how the
+// reconciliation is retried depending on err.
+func (r *ProjectReconciler) failProject(ctx context.Context, project *appv1.Project, original *appv1.ProjectStatus, reason string, err error) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	addCondition(logger, &project.Status.Conditions, project.Generation, conditionSynced, metav1.ConditionFalse, reason, err.Error())
+	setStalled(logger, &project.Status.Conditions, project.Generation, err)
+	r.Recorder.Event(project, v1.EventTypeWarning, reason, err.Error())
+	_ = r.updateProjectStatus(ctx, project, original)
+
+	return retryAfterError(r.Resync, err)
+}
+
+// updateProjectStatus derives the Ready condition and writes the status when
+// it differs from original.
+func (r *ProjectReconciler) updateProjectStatus(ctx context.Context, project *appv1.Project, original *appv1.ProjectStatus) error {
+	logger := log.FromContext(ctx)
+
+	setReady(logger, &project.Status.Conditions, project.Generation)
+	project.Status.ObservedGeneration = project.Generation
+
+	if equality.Semantic.DeepEqual(original, &project.Status) {
+		return nil
+	}
+
+	if err := r.Status().Update(ctx, project); err != nil {
+		logger.Error(err, "Failed to update Project status.", "project", project.Name)
+		return err
+	}
 
-	return ctrl.Result{}, nil
+	return nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ProjectReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1.Project{}, projectInstanceIndex, indexProjectInstances); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1.GitlabInstance{}, instanceSecretIndex, indexInstanceSecrets); err != nil {
+		return err
+	}
+
+	if err := mgr.Add(manager.RunnableFunc(r.purgeProjects)); err != nil {
+		return err
+	}
+
+	if err := registerResourceCollector(mgr.GetClient(), r.PerResourceMetrics); err != nil {
+		return err
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&appv1.Project{}).
-		Complete(r)
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(&source.Kind{Type: &appv1.GitlabInstance{}}, handler.EnqueueRequestsFromMapFunc(r.projectsUsing)).
+		Watches(&source.Kind{Type: &v1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.projectsUsingSecret), builder.OnlyMetadata)
+
+	if r.Hooks != nil {
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1.Project{}, projectPathIndex, indexProjectPaths); err != nil {
+			return err
+		}
+
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1.Project{}, projectIDIndex, indexProjectIDs); err != nil {
+			return err
+		}
+
+		if err := mgr.Add(r.Hooks); err != nil {
+			return err
+		}
+
+		b = b.Watches(r.Hooks.source(), &handler.EnqueueRequestForObject{})
+	}
+
+	return b.Complete(r)
 }
@@ -0,0 +1,160 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/gitlab"
+	"github.com/vbouchaud/wellerman/internal/gitlab/gitlabtest"
+)
+
+var _ = Describe("Project reconciliation", func() {
+	var (
+		k8s      client.Client
+		server   *gitlabtest.Server
+		projects *ProjectReconciler
+	)
+
+	BeforeEach(func() {
+		k8s = newFakeClient()
+		server = gitlabtest.NewServer()
+		DeferCleanup(server.Close)
+
+		instance, err := gitlab.NewInstance(server.URL, "token", gitlab.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		projects = &ProjectReconciler{Client: k8s, Scheme: scheme.Scheme, Recorder: newRecorder(), Gitlab: instance}
+	})
+
+	// appliedWith returns a Project whose last applied configuration holds
+	// the applied paths, while its spec holds paths.
+	appliedWith := func(applied, paths []string) *appv1.Project {
+		project := &appv1.Project{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "edited",
+				Namespace:  "default",
+				Finalizers: []string{projectFinalizer.name},
+			},
+		}
+		for _, path := range applied {
+			project.Spec.Paths = append(project.Spec.Paths, appv1.ProjectPath{Path: path})
+		}
+		lastApplied, err := json.Marshal(project)
+		Expect(err).NotTo(HaveOccurred())
+
+		project.Annotations = map[string]string{v1.LastAppliedConfigAnnotation: string(lastApplied)}
+		project.Spec.Paths = nil
+		for _, path := range paths {
+			project.Spec.Paths = append(project.Spec.Paths, appv1.ProjectPath{Path: path})
+		}
+		return project
+	}
+
+	It("archives the paths removed since the last apply", func() {
+		kept := server.AddProject("infra/kept")
+		removed := server.AddProject("infra/removed")
+
+		project := appliedWith([]string{"infra/kept", "infra/removed"}, []string{"infra/kept"})
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+
+		_, err := projects.Reconcile(ctx, requestFor(project))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.Project("infra/removed").Archived).To(BeTrue())
+		Expect(server.Writes()).NotTo(ContainElement(fmt.Sprintf("POST /projects/%d/archive", kept.ID)))
+		Expect(server.Writes()).To(ContainElement(fmt.Sprintf("POST /projects/%d/archive", removed.ID)))
+	})
+
+	It("leaves the paths added since the last apply in place", func() {
+		server.AddProject("infra/kept")
+
+		project := appliedWith([]string{"infra/kept"}, []string{"infra/kept", "infra/added"})
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+
+		for i := 0; i < 2; i++ {
+			_, err := projects.Reconcile(ctx, requestFor(project))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		added := server.Project("infra/added")
+		Expect(added).NotTo(BeNil())
+		Expect(added.Archived).To(BeFalse())
+		for _, write := range server.Writes() {
+			Expect(write).NotTo(HaveSuffix("/archive"))
+			Expect(write).NotTo(HavePrefix("DELETE "))
+		}
+	})
+	It("reports a retained path once", func() {
+		recorder := newRecorder()
+		projects.Recorder = recorder
+		server.AddProject("infra/kept")
+		server.AddProject("infra/retained")
+
+		project := appliedWith([]string{"infra/kept"}, []string{"infra/kept"})
+		lastApplied, err := json.Marshal(&appv1.Project{Spec: appv1.ProjectSpec{Paths: []appv1.ProjectPath{
+			{Path: "infra/kept"},
+			{Path: "infra/retained", DeletionPolicy: appv1.DeletionRetain},
+		}}})
+		Expect(err).NotTo(HaveOccurred())
+		project.Annotations[v1.LastAppliedConfigAnnotation] = string(lastApplied)
+		project.Status.Paths = []appv1.ProjectPathStatus{
+			{Path: "infra/kept", State: appv1.PathSynced},
+			{Path: "infra/retained", State: appv1.PathSynced},
+		}
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+
+		for i := 0; i < 3; i++ {
+			_, err = projects.Reconcile(ctx, requestFor(project))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		var retained int
+		for len(recorder.Events) > 0 {
+			if event := <-recorder.Events; strings.Contains(event, "ProjectRetained") {
+				retained++
+			}
+		}
+		Expect(retained).To(Equal(1))
+		Expect(server.Project("infra/retained").Archived).To(BeFalse())
+	})
+
+	It("reports a project deleted out of band with the report drift policy", func() {
+		projects.Resync = ResyncOptions{DriftPolicy: DriftPolicyReport}
+
+		project := &appv1.Project{
+			ObjectMeta: metav1.ObjectMeta{Name: "drifted", Namespace: "default", Generation: 1, Finalizers: []string{projectFinalizer.name}},
+			Spec:       appv1.ProjectSpec{Paths: []appv1.ProjectPath{{Path: "infra/drifted"}}},
+		}
+		Expect(k8s.Create(ctx, project)).To(Succeed())
+
+		_, err := projects.Reconcile(ctx, requestFor(project))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.Project("infra/drifted")).NotTo(BeNil())
+
+		server.RemoveProject("infra/drifted")
+		for i := 0; i < 2; i++ {
+			_, err = projects.Reconcile(ctx, requestFor(project))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(server.Project("infra/drifted")).To(BeNil())
+
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(project), project)).To(Succeed())
+		Expect(project.Status.Paths).To(HaveLen(1))
+		Expect(project.Status.Paths[0].State).To(Equal(appv1.PathDrifted))
+		Expect(meta.IsStatusConditionTrue(project.Status.Conditions, conditionDrifted)).To(BeTrue())
+	})
+})
@@ -0,0 +1,219 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/gitlab"
+)
+
+// projectPathIndex indexes Projects by the paths of the operator instance
+// they manage, and by every group these paths are in.
+const projectPathIndex = "spec.paths.path"
+
+func indexProjectPaths(obj client.Object) []string {
+	var keys []string
+
+	for _, projectPath := range obj.(*appv1.Project).Spec.Paths {
+		if projectPath.Instance != "" {
+			continue
+		}
+		for p := projectPath.Path; p != "." && p != "/"; p = path.Dir(p) {
+			keys = append(keys, p)
+		}
+	}
+
+	return keys
+}
+
+// projectIDIndex indexes Projects by the ids of the projects they manage on
+// the operator instance.
+const projectIDIndex = "status.paths.projectID"
+
+func indexProjectIDs(obj client.Object) []string {
+	var keys []string
+
+	for _, status := range obj.(*appv1.Project).Status.Paths {
+		if status.Instance == "" && status.ProjectID != 0 {
+			keys = append(keys, strconv.Itoa(status.ProjectID))
+		}
+	}
+
+	return keys
+}
+
+// maxHookSize bounds the body of the hooks read.
+const maxHookSize = 1 << 20
+
+// HookReceiver serves the system hooks of the operator GitLab instance and
+// has the Projects they are about reconciled, for changes made on GitLab to
+// be noticed without waiting for the next resync.
+type HookReceiver struct {
+	client.Client
+
+	// Address is the address the hooks are served on.
+	Address string
+	// Gitlab is the client of the instance, whose cache is updated from the
+	// hooks.
+	Gitlab *gitlab.Client
+
+	token  atomic.Pointer[string]
+	events chan event.GenericEvent
+}
+
+// SetToken replaces the secret token the hooks are checked against.
+func (h *HookReceiver) SetToken(token string) error {
+	if token == "" {
+		return errors.New("the gitlab hook token must not be empty")
+	}
+	h.token.Store(&token)
+	return nil
+}
+
+// source returns the source of the Projects enqueued by the hooks.
+func (h *HookReceiver) source() source.Source {
+	if h.events == nil {
+		h.events = make(chan event.GenericEvent, 100)
+	}
+	return &source.Channel{Source: h.events}
+}
+
+// NeedLeaderElection tells the manager to only serve the hooks on the leader,
+// the only replica reconciling the Projects they enqueue. The other replicas
+// do not listen, a hook sent to them is refused.
+// It implements manager.LeaderElectionRunnable.
+func (h *HookReceiver) NeedLeaderElection() bool {
+	return true
+}
+
+// Start serves the hooks until ctx is done. It implements manager.Runnable.
+func (h *HookReceiver) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/hooks/gitlab", h)
+
+	server := &http.Server{
+		Addr:              h.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return server.Shutdown(shutdown)
+}
+
+func (h *HookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	logger := log.FromContext(req.Context()).WithName("gitlab-hooks")
+
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := h.token.Load()
+	if token == nil || subtle.ConstantTimeCompare([]byte(req.Header.Get(gitlab.HookTokenHeader)), []byte(*token)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxHookSize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	hook, err := gitlab.ParseHook(body)
+	if err != nil {
+		logger.Error(err, "Failed to parse gitlab hook.")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	for _, p := range hook.Paths {
+		h.Gitlab.Forget(p)
+	}
+
+	projects, err := h.projectsFor(req.Context(), hook)
+	if err != nil {
+		logger.Error(err, "Failed to list the Projects of a gitlab hook.", "event", hook.Name)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	for _, project := range projects {
+		logger.V(1).Info("Enqueuing Project from gitlab hook.", "event", hook.Name, "project", client.ObjectKeyFromObject(project))
+		select {
+		case h.events <- event.GenericEvent{Object: project}:
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// projectsFor returns the Projects managing the paths and the project hook is
+// about.
+func (h *HookReceiver) projectsFor(ctx context.Context, hook *gitlab.HookEvent) ([]*appv1.Project, error) {
+	var (
+		projects []*appv1.Project
+		seen     = map[client.ObjectKey]bool{}
+	)
+
+	add := func(field, value string) error {
+		list := &appv1.ProjectList{}
+		if err := h.List(ctx, list, client.MatchingFields{field: value}); err != nil {
+			return err
+		}
+		for i := range list.Items {
+			key := client.ObjectKeyFromObject(&list.Items[i])
+			if !seen[key] {
+				seen[key] = true
+				projects = append(projects, &list.Items[i])
+			}
+		}
+		return nil
+	}
+
+	for _, p := range hook.Paths {
+		if err := add(projectPathIndex, p); err != nil {
+			return nil, err
+		}
+	}
+	if hook.ProjectID != 0 {
+		if err := add(projectIDIndex, strconv.Itoa(hook.ProjectID)); err != nil {
+			return nil, err
+		}
+	}
+
+	return projects, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/gitlab"
+	"github.com/vbouchaud/wellerman/internal/gitlab/gitlabtest"
+)
+
+var _ = Describe("HookReceiver", func() {
+	var (
+		k8s      client.Client
+		receiver *HookReceiver
+		events   chan event.GenericEvent
+	)
+
+	newProject := func(name string, paths ...appv1.ProjectPath) *appv1.Project {
+		return &appv1.Project{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       appv1.ProjectSpec{Paths: paths},
+		}
+	}
+
+	// send posts body to the receiver with token, and returns the status
+	// it answered with.
+	send := func(token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/hooks/gitlab", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(gitlab.HookTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		receiver.ServeHTTP(w, req.WithContext(ctx))
+		return w.Code
+	}
+
+	// enqueued returns the names of the Projects enqueued so far.
+	enqueued := func() []string {
+		var names []string
+		for {
+			select {
+			case e := <-events:
+				names = append(names, e.Object.GetName())
+			default:
+				return names
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		server := gitlabtest.NewServer()
+		DeferCleanup(server.Close)
+		instance, err := gitlab.NewInstance(server.URL, "token", gitlab.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		synced := newProject("synced", appv1.ProjectPath{Path: "infra/tools/synced"})
+		synced.Status.Paths = []appv1.ProjectPathStatus{{Path: "infra/tools/synced", State: appv1.PathSynced, ProjectID: 42}}
+		k8s = newFakeClient(
+			synced,
+			newProject("platform", appv1.ProjectPath{Path: "platform/app"}),
+			newProject("elsewhere", appv1.ProjectPath{Instance: "corp", Path: "infra/tools/elsewhere"}),
+		)
+
+		receiver = &HookReceiver{Client: k8s, Gitlab: instance}
+		receiver.source()
+		events = receiver.events
+		Expect(receiver.SetToken("secret")).To(Succeed())
+	})
+
+	It("refuses hooks without the token", func() {
+		Expect(send("", `{"event_name": "group_rename", "full_path": "infra"}`)).To(Equal(http.StatusUnauthorized))
+		Expect(send("wrong", `{"event_name": "group_rename", "full_path": "infra"}`)).To(Equal(http.StatusUnauthorized))
+		Expect(enqueued()).To(BeEmpty())
+	})
+
+	It("refuses empty tokens", func() {
+		Expect(receiver.SetToken("")).NotTo(Succeed())
+	})
+
+	It("refuses malformed hooks", func() {
+		Expect(send("secret", `{"event_name": `)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("enqueues the Projects under a group of the operator instance", func() {
+		Expect(send("secret", `{"event_name": "group_rename", "full_path": "infra/tools", "old_full_path": "infra/tooling"}`)).To(Equal(http.StatusNoContent))
+		Expect(enqueued()).To(ConsistOf("synced"))
+
+		Expect(send("secret", `{"event_name": "group_update", "full_path": "infra"}`)).To(Equal(http.StatusNoContent))
+		Expect(enqueued()).To(ConsistOf("synced"))
+
+		Expect(send("secret", `{"event_name": "group_rename", "full_path": "infra/to"}`)).To(Equal(http.StatusNoContent))
+		Expect(enqueued()).To(BeEmpty())
+	})
+
+	It("enqueues the Projects managing a project by its id", func() {
+		Expect(send("secret", `{"event_name": "project_rename", "project_id": 42, "path_with_namespace": "infra/renamed", "old_path_with_namespace": "infra/old"}`)).To(Equal(http.StatusNoContent))
+		Expect(enqueued()).To(ConsistOf("synced"))
+	})
+
+	It("enqueues a Project once per hook", func() {
+		Expect(send("secret", `{"event_name": "project_update", "project_id": 42, "path_with_namespace": "infra/tools/synced"}`)).To(Equal(http.StatusNoContent))
+		Expect(enqueued()).To(Equal([]string{"synced"}))
+	})
+})
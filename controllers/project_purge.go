@@ -0,0 +1,70 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/tracing"
+)
+
+// purgeInterval is how often projects pending deletion are checked for the
+// end of their grace period.
+const purgeInterval = 10 * time.Minute
+
+// purgeProjects deletes, every purgeInterval until ctx is done, the projects
+// whose grace period ended on the operator instance and every
+// GitlabInstance. It implements manager.Runnable.
+func (r *ProjectReconciler) purgeProjects(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("project-purge")
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if !r.AllowHardDelete || r.Mode == appv1.ReconcilePaused {
+			continue
+		}
+
+		paths := []appv1.ProjectPath{{}}
+
+		instances := &appv1.GitlabInstanceList{}
+		if err := r.List(ctx, instances); err != nil {
+			logger.Error(err, "Failed to list GitlabInstances.")
+		}
+		for _, instance := range instances.Items {
+			paths = append(paths, appv1.ProjectPath{Instance: instance.Name})
+		}
+
+		for _, projectPath := range paths {
+			instance, err := r.gitlabFor(ctx, "", projectPath)
+			if err != nil {
+				logger.Error(err, "Failed to get GitlabInstance client.", "instance", projectPath.Instance)
+				continue
+			}
+
+			purgeCtx, span := tracing.Start(ctx, "project purge", attribute.String("gitlab.instance", projectPath.Instance))
+			err, changes := instance.PurgeProjects(purgeCtx, time.Now(), r.Mode == appv1.ReconcileDryRun)
+			tracing.End(span, err)
+			for _, change := range changes {
+				logger.Info(change.Message+".", "instance", projectPath.Instance, "reason", change.Reason, "dry-run", r.Mode == appv1.ReconcileDryRun)
+			}
+			if err != nil {
+				logger.Error(err, "Failed to purge projects pending deletion.", "instance", projectPath.Instance)
+			}
+		}
+	}
+}
@@ -6,106 +6,519 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/backend"
+	"github.com/vbouchaud/wellerman/internal/credentials"
 	"github.com/vbouchaud/wellerman/internal/ldap"
 )
 
+// GroupDirectory is what Teams need of the directory their group is managed
+// in. It is implemented by *ldap.Client.
+type GroupDirectory interface {
+	GroupDN(team appv1.TeamObject, groupName string) (string, error)
+	LegacyGroupDN(team appv1.TeamObject) string
+	ReconcileGroup(ctx context.Context, team appv1.TeamObject, groupName, previous string, members []string, dryRun bool) (string, error, []ldap.Change)
+	DeleteGroup(ctx context.Context, groupDN string, dryRun bool) (error, []ldap.Change)
+	ResolveUser(ctx context.Context, login string) (string, error)
+	ResolveEmail(ctx context.Context, email string) (string, error)
+	SearchUsers(ctx context.Context, filter string) ([]string, error)
+}
+
 // TeamReconciler reconciles a Team object
 type TeamReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Ldap   *ldap.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Ldap     GroupDirectory
+
+	// MemberFilterResyncPeriod is how often Teams with a member filter are
+	// reconciled to pick up people joining or leaving.
+	MemberFilterResyncPeriod time.Duration
+
+	// Mode is the mode Teams are reconciled in when they do not set one with
+	// the reconcile annotation.
+	Mode appv1.ReconcileMode
+
+	// RequireDeletionConfirmation keeps the group of a deleted Team that still
+	// has members until the Team holds the confirm-deletion annotation.
+	RequireDeletionConfirmation bool
+
+	// Resync sets how often groups are checked for changes made outside of
+	// wellerman.
+	Resync ResyncOptions
+
+	// MaxConcurrentReconciles is how many Teams are reconciled at once.
+	MaxConcurrentReconciles int
+
+	// PerResourceMetrics reports metrics by resource, along with those by
+	// kind. It adds series for every resource.
+	PerResourceMetrics bool
+
+	// NamingStrategy derives the group name of Teams without an explicit one.
+	NamingStrategy string
+
+	// DirectoryDefaults completes the clients built for Directories.
+	DirectoryDefaults DirectoryDefaults
+
+	// Credentials records the Secrets read for Directories.
+	Credentials *credentials.Registry
+
+	// APIReader reads the data of the Secrets of Directories, which the
+	// manager only caches the metadata of.
+	APIReader client.Reader
+
+	directories clientCache[*ldap.Client]
+	secrets     secretCache
+	claims      groupClaims
 }
 
-const teamFinalizer = "app.heidrun.bouchaud.org/team-finalizer"
+var teamFinalizer = finalizer{
+	name:   "app.wellerman.bouchaud.org/team-finalizer",
+	legacy: "app.heidrun.bouchaud.org/team-finalizer",
+}
+
+// errNoMembers is returned when none of the members of a Team resolved, the
+// group is then left as is rather than emptied.
+var errNoMembers = backend.New(backend.NotFound, "none of the members of the Team could be resolved, the group is left unchanged")
+
+// conflictRequeueDelay is how often a Team whose group is managed by another
+// Team checks whether the group was released.
+const conflictRequeueDelay = 5 * time.Minute
 
 //+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=teams,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=teams/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=teams/finalizers,verbs=update
+//+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=directories,verbs=get;list;watch
+//+kubebuilder:rbac:groups=app.wellerman.bouchaud.org,resources=directories/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 func (r *TeamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return traced(ctx, "Team", req, func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		return r.reconcileTeam(ctx, req, &appv1.Team{})
+	})
+}
+
+// reconcileTeam holds the reconciliation of both Teams and ClusterTeams.
+func (r *TeamReconciler) reconcileTeam(ctx context.Context, req ctrl.Request, team appv1.TeamObject) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
 	logger.Info("Reconciling Team.")
 
 	// Fetch the Team instance
-	team := &appv1.Team{}
 	err := r.Get(ctx, req.NamespacedName, team)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			logger.Info("Team resource not found. Ignoring since object must be deleted.")
+			forgetSynced(kindOf(team), req.Namespace, req.Name)
 			return ctrl.Result{}, nil
 		}
 		logger.Error(err, "Failed to get Team.")
 		return ctrl.Result{}, err
 	}
 
+	spec, status := team.GetSpec(), team.GetStatus()
+	groupName := r.groupName(team)
+
+	mode := appv1.ModeOf(team.GetAnnotations(), r.Mode)
+	if mode == appv1.ReconcilePaused {
+		logger.Info("Team reconciliation paused.", "ldap-group", groupName)
+		original := status.DeepCopy()
+		addCondition(logger, &status.Conditions, team.GetGeneration(), conditionSynced, metav1.ConditionUnknown, "Paused", fmt.Sprintf("Reconciliation is paused, see the %s annotation.", appv1.ReconcileAnnotation))
+		return ctrl.Result{}, r.updateTeamStatus(ctx, team, original)
+	}
+	dryRun := mode == appv1.ReconcileDryRun
+
+	if teamFinalizer.migrate(team) {
+		if err = r.Update(ctx, team); err != nil {
+			logger.Error(err, "Failed to migrate finalizer.", "ldap-group", groupName)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// A Directory that is missing, or that does not allow the namespace of the
+	// Team, leaves directory nil and is reported with directoryErr.
+	directory, directoryErr := r.ldapFor(ctx, team)
+	if directoryErr != nil && !errors.IsNotFound(directoryErr) && !backend.IsTerminal(directoryErr) {
+		logger.Error(directoryErr, "Failed to get Directory client.", "directory", spec.Directory)
+		return ctrl.Result{}, directoryErr
+	}
+
 	// Team deletion
 	isTeamMarkedToBeDeleted := team.GetDeletionTimestamp() != nil
 	if isTeamMarkedToBeDeleted {
-		if controllerutil.ContainsFinalizer(team, teamFinalizer) {
-			if err := r.Ldap.DeleteGroup(team.Name); err != nil {
-				if ldap.IsNotFound(err) {
-					logger.Info("Ldap group not found.", "ldap-group", team.Name)
-				} else {
-					logger.Error(err, "Error while removing group.", "ldap-group", team.Name)
-					return ctrl.Result{}, err
+		if teamFinalizer.heldBy(team) {
+			if r.RequireDeletionConfirmation && len(status.Members) > 0 && team.GetAnnotations()[appv1.ConfirmDeletionAnnotation] != "true" {
+				logger.Info("Team deletion not confirmed, keeping the group.", "ldap-group", groupName)
+				message := fmt.Sprintf("The group still has %d members, set the %s annotation to \"true\" to confirm its deletion.", len(status.Members), appv1.ConfirmDeletionAnnotation)
+				original := status.DeepCopy()
+				addCondition(logger, &status.Conditions, team.GetGeneration(), conditionSynced, metav1.ConditionFalse, "DeletionNotConfirmed", message)
+				r.Recorder.Event(team, v1.EventTypeWarning, "DeletionNotConfirmed", message)
+				return ctrl.Result{}, r.updateTeamStatus(ctx, team, original)
+			}
+
+			if directory == nil {
+				if team.GetAnnotations()[appv1.ConfirmDeletionAnnotation] != "true" {
+					logger.Info("Directory unavailable, keeping the Team until it is back or the deletion is confirmed.", "directory", spec.Directory)
+					message := fmt.Sprintf("The group cannot be deleted: %s. Fix the Directory, or set the %s annotation to \"true\" to delete the Team and leave the group in place.", directoryErr, appv1.ConfirmDeletionAnnotation)
+					original := status.DeepCopy()
+					addCondition(logger, &status.Conditions, team.GetGeneration(), conditionSynced, metav1.ConditionFalse, "DirectoryUnavailable", message)
+					r.Recorder.Event(team, v1.EventTypeWarning, "DirectoryUnavailable", message)
+					return ctrl.Result{}, r.updateTeamStatus(ctx, team, original)
 				}
+				logger.Info("Directory unavailable, deletion confirmed, leaving the group in place.", "directory", spec.Directory, "ldap-group", status.DistinguishedName)
+				r.Recorder.Event(team, v1.EventTypeWarning, "GroupLeftInPlace", fmt.Sprintf("The group %s was left in place: %s.", status.DistinguishedName, directoryErr))
+			} else if err := r.deleteGroup(ctx, team, directory, groupName, dryRun); err != nil {
+				return retryAfterError(r.Resync, err)
+			}
+
+			// In dry-run, the group is left in place and so is the finalizer,
+			// for the deletion to be done once the Team leaves dry-run.
+			if dryRun {
+				logger.Info("Team deletion planned, keeping the finalizer until it leaves dry-run.", "ldap-group", groupName)
+				original := status.DeepCopy()
+				addCondition(logger, &status.Conditions, team.GetGeneration(), conditionSynced, metav1.ConditionFalse, "DryRun", fmt.Sprintf("Planned: the group is deleted once the Team leaves dry-run, see the %s annotation.", appv1.ReconcileAnnotation))
+				return ctrl.Result{}, r.updateTeamStatus(ctx, team, original)
 			}
 
-			controllerutil.RemoveFinalizer(team, teamFinalizer)
+			teamFinalizer.remove(team)
 			if err = r.Update(ctx, team); err != nil {
-				logger.Error(err, "Failed to remove finalizer.", "ldap-group", team.Name)
+				logger.Error(err, "Failed to remove finalizer.", "ldap-group", groupName)
 				return ctrl.Result{}, err
 			}
+			r.claims.release(team.GetUID())
 		}
 		return ctrl.Result{}, nil
 	}
 
 	// Team Initialization
-	if !controllerutil.ContainsFinalizer(team, teamFinalizer) {
-		controllerutil.AddFinalizer(team, teamFinalizer)
-		addCondition(logger, &team.Status.Conditions, conditionConfigured, metav1.ConditionFalse)
+	if !teamFinalizer.heldBy(team) {
+		controllerutil.AddFinalizer(team, teamFinalizer.name)
 		if err = r.Update(ctx, team); err != nil {
-			logger.Error(err, "Failed to initialize Team status.", "ldap-group", team.Name)
+			logger.Error(err, "Failed to add finalizer.", "ldap-group", groupName)
 			return ctrl.Result{}, err
 		}
 	}
 
-	// Team update
-	changed := false
+	original := status.DeepCopy()
+	generation := team.GetGeneration()
 
-	team.Status.DistinguishedName, err, changed = r.Ldap.ReconcileGroup(team)
+	if directory == nil && backend.IsTerminal(directoryErr) {
+		return r.failTeam(ctx, team, original, "DirectoryNotAllowed", directoryErr)
+	}
+	if directory == nil {
+		addCondition(logger, &status.Conditions, generation, conditionSynced, metav1.ConditionFalse, "DirectoryNotFound", fmt.Sprintf("The Directory %s, or a Secret it references, does not exist.", spec.Directory))
+		return ctrl.Result{}, r.updateTeamStatus(ctx, team, original)
+	}
+
+	// Team members resolution
+	resolver, err := r.resolveMembers(ctx, team, directory)
+	if err != nil {
+		logger.Error(err, "Failed to resolve Team members.", "ldap-group", groupName)
+		return r.failTeam(ctx, team, original, "MemberResolutionFailed", err)
+	}
+	members := resolver.dns
+	status.MemberStatuses = resolver.statuses
+
+	if len(resolver.unresolved) > 0 {
+		message := strings.Join(resolver.unresolved, ", ")
+		addCondition(logger, &status.Conditions, generation, conditionMembersResolved, metav1.ConditionFalse, "UnresolvableMembers", message)
+		addCondition(logger, &status.Conditions, generation, conditionDegraded, metav1.ConditionTrue, "UnresolvableMembers", message)
+	} else {
+		addCondition(logger, &status.Conditions, generation, conditionMembersResolved, metav1.ConditionTrue, "Resolved", "")
+		addCondition(logger, &status.Conditions, generation, conditionDegraded, metav1.ConditionFalse, "Resolved", "")
+	}
+	if len(members) == 0 {
+		logger.Info("No member could be resolved, leaving the group unchanged.", "ldap-group", groupName)
+		return r.failTeam(ctx, team, original, "NoMembers", errNoMembers)
+	}
+	result := ctrl.Result{}
+	if resolver.dynamic {
+		result.RequeueAfter = r.MemberFilterResyncPeriod
+	}
+
+	// Group name collision
+	groupDN, err := directory.GroupDN(team, groupName)
+	if err != nil {
+		logger.Error(err, "Failed to compute group DN.", "ldap-group", groupName)
+		return r.failTeam(ctx, team, original, "InvalidGroupDN", err)
+	}
+
+	other, err := r.claimGroup(ctx, team, groupDN)
 	if err != nil {
-		logger.Error(err, "Failed to crupdate Team resource.", "ldap-group", team.Name)
 		return ctrl.Result{}, err
 	}
+	if other != nil {
+		r.claims.release(team.GetUID())
+		message := fmt.Sprintf("The group %s is already managed by %s.", groupDN, client.ObjectKeyFromObject(other))
+		addCondition(logger, &status.Conditions, generation, conditionConflict, metav1.ConditionTrue, "GroupNameTaken", message)
+		addCondition(logger, &status.Conditions, generation, conditionSynced, metav1.ConditionFalse, "GroupNameTaken", message)
+		status.DistinguishedName = ""
+		if err = r.updateTeamStatus(ctx, team, original); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: conflictRequeueDelay}, nil
+	}
 
-	if changed {
-		addCondition(logger, &team.Status.Conditions, conditionConfigured, metav1.ConditionTrue)
-		if err = r.Update(ctx, team); err != nil {
-			logger.Error(err, "Failed to update Team status.", "ldap-group", team.Name)
+	// Groups of Teams reconciled before their DN was recorded in their status
+	// are looked up where they used to be created, before the DN template
+	// existed, so that they get moved. A Team that lost its group to another
+	// one has none to move.
+	previous := status.DistinguishedName
+	if previous == "" && meta.FindStatusCondition(original.Conditions, conditionConflict) == nil {
+		legacyDN := directory.LegacyGroupDN(team)
+		if other, err = r.claimedBy(ctx, team, legacyDN); err != nil {
+			return ctrl.Result{}, err
+		}
+		if other == nil {
+			previous = legacyDN
+		}
+	}
+
+	// Changes to a group whose spec and members were already applied are
+	// drift, only reported when so configured.
+	drifting := specApplied(original.Conditions, generation) && equality.Semantic.DeepEqual(original.Members, members)
+	dryRun = dryRun || (drifting && r.Resync.reportOnly())
+
+	// With the Additive policy, only the members wellerman added are ever
+	// removed, they are recorded before being added for a status update that
+	// fails after the write not to have them forgotten.
+	if spec.MembershipPolicy == appv1.MembershipAdditive && !dryRun {
+		if err = r.recordManagedMembers(ctx, team, members); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
-	return ctrl.Result{}, nil
+	// Team update
+	var changes []ldap.Change
+
+	status.DistinguishedName, err, changes = directory.ReconcileGroup(ctx, team, groupName, previous, members, dryRun)
+	r.recordChanges(team, changes, dryRun)
+	if err != nil && ldap.IsNotOwned(err) {
+		r.claims.release(team.GetUID())
+		status.DistinguishedName = ""
+		if spec.AdoptionPolicy == appv1.AdoptionRefuse {
+			message := "The group already exists and is managed by another process, refusing to modify it."
+			addCondition(logger, &status.Conditions, generation, conditionConflict, metav1.ConditionTrue, "GroupNotOwned", message)
+			addCondition(logger, &status.Conditions, generation, conditionSynced, metav1.ConditionFalse, "GroupNotOwned", message)
+		} else {
+			message := "The group already exists and is managed by another process, only observing it."
+			addCondition(logger, &status.Conditions, generation, conditionConflict, metav1.ConditionFalse, "Observed", message)
+			addCondition(logger, &status.Conditions, generation, conditionSynced, metav1.ConditionFalse, "Observed", message)
+		}
+		return result, r.updateTeamStatus(ctx, team, original)
+	}
+	if err != nil {
+		logger.Error(err, "Failed to crupdate Team resource.", "ldap-group", groupName)
+		return r.failTeam(ctx, team, original, "GroupUpdateFailed", err)
+	}
+	meta.RemoveStatusCondition(&status.Conditions, conditionConflict)
+	setStalled(logger, &status.Conditions, generation, nil)
+	if dryRun {
+		status.DistinguishedName = original.DistinguishedName
+		status.Members = original.Members
+	} else {
+		status.Members = members
+	}
+
+	if mode == appv1.ReconcileDryRun && len(changes) > 0 {
+		planned := make([]string, 0, len(changes))
+		for _, change := range changes {
+			planned = append(planned, change.Message)
+		}
+		addCondition(logger, &status.Conditions, generation, conditionSynced, metav1.ConditionFalse, "DryRun", "Planned: "+strings.Join(planned, "; "))
+	} else {
+		addCondition(logger, &status.Conditions, generation, conditionSynced, metav1.ConditionTrue, "Synced", "")
+	}
+
+	var drift []string
+	if drifting {
+		for _, change := range changes {
+			drift = append(drift, change.Message)
+		}
+	}
+	setDrifted(logger, &status.Conditions, generation, drift, dryRun)
+
+	if err = r.updateTeamStatus(ctx, team, original); err != nil {
+		return ctrl.Result{}, err
+	}
+	if meta.IsStatusConditionTrue(status.Conditions, conditionSynced) {
+		markSynced(team)
+	}
+
+	return r.Resync.requeue(result), nil
+}
+
+// failTeam records why a Team could not be synced, and tells how the
+// reconciliation is retried depending on err.
+func (r *TeamReconciler) failTeam(ctx context.Context, team appv1.TeamObject, original *appv1.TeamStatus, reason string, err error) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	status := team.GetStatus()
+
+	addCondition(logger, &status.Conditions, team.GetGeneration(), conditionSynced, metav1.ConditionFalse, reason, err.Error())
+	setStalled(logger, &status.Conditions, team.GetGeneration(), err)
+	r.Recorder.Event(team, v1.EventTypeWarning, reason, err.Error())
+	_ = r.updateTeamStatus(ctx, team, original)
+
+	return retryAfterError(r.Resync, err)
+}
+
+// updateTeamStatus derives the Ready condition and writes the status when it
+// differs from original.
+func (r *TeamReconciler) updateTeamStatus(ctx context.Context, team appv1.TeamObject, original *appv1.TeamStatus) error {
+	logger := log.FromContext(ctx)
+	status := team.GetStatus()
+
+	setReady(logger, &status.Conditions, team.GetGeneration())
+	status.ObservedGeneration = team.GetGeneration()
+
+	if equality.Semantic.DeepEqual(original, status) {
+		return nil
+	}
+
+	if err := r.Status().Update(ctx, team); err != nil {
+		logger.Error(err, "Failed to update Team status.")
+		return err
+	}
+
+	return nil
+}
+
+// recordManagedMembers adds members to the members of the status of team, and
+// writes the status when it changed.
+func (r *TeamReconciler) recordManagedMembers(ctx context.Context, team appv1.TeamObject, members []string) error {
+	status := team.GetStatus()
+
+	seen := map[string]bool{}
+	for _, member := range status.Members {
+		seen[strings.ToLower(member)] = true
+	}
+
+	managed := status.Members
+	for _, member := range members {
+		if !seen[strings.ToLower(member)] {
+			managed = append(managed, member)
+		}
+	}
+	if len(managed) == len(status.Members) {
+		return nil
+	}
+
+	status.Members = managed
+	if err := r.Status().Update(ctx, team); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to record managed members.")
+		return err
+	}
+
+	return nil
+}
+
+// deleteGroup removes the group of a deleted Team, unless another Team manages
+// it or it was not created by wellerman. With dryRun, the deletion is only
+// reported.
+func (r *TeamReconciler) deleteGroup(ctx context.Context, team appv1.TeamObject, directory GroupDirectory, groupName string, dryRun bool) error {
+	logger := log.FromContext(ctx)
+
+	groupDN := team.GetStatus().DistinguishedName
+	if groupDN == "" {
+		var err error
+		if groupDN, err = directory.GroupDN(team, groupName); err != nil {
+			logger.Error(err, "Error while computing group DN.", "ldap-group", groupName)
+			return err
+		}
+	}
+
+	other, err := r.claimedBy(ctx, team, groupDN)
+	if err != nil {
+		return err
+	}
+
+	if other != nil {
+		logger.Info("Ldap group managed by another Team, leaving it in place.", "ldap-group", groupDN, "team", client.ObjectKeyFromObject(other))
+		return nil
+	}
+
+	err, changes := directory.DeleteGroup(ctx, groupDN, dryRun)
+	r.recordChanges(team, changes, dryRun)
+	switch {
+	case err == nil && len(changes) == 0:
+		logger.Info("Ldap group already removed.", "ldap-group", groupDN)
+	case ldap.IsNotOwned(err):
+		logger.Info("Ldap group not managed by wellerman, leaving it in place.", "ldap-group", groupDN)
+	case err != nil:
+		logger.Error(err, "Error while removing group.", "ldap-group", groupDN)
+		r.Recorder.Event(team, v1.EventTypeWarning, "GroupDeletionFailed", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// recordChanges reports the writes done on the directory as events on team,
+// or the writes that would have been done with dryRun.
+func (r *TeamReconciler) recordChanges(team appv1.TeamObject, changes []ldap.Change, dryRun bool) {
+	for _, change := range changes {
+		if dryRun {
+			r.Recorder.Event(team, v1.EventTypeWarning, change.Reason, "[dry-run] "+change.Message)
+		} else {
+			r.Recorder.Event(team, v1.EventTypeNormal, change.Reason, change.Message)
+		}
+	}
+}
+
+func (r *TeamReconciler) setupIndexes(mgr ctrl.Manager, obj client.Object) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), obj, teamMemberIndex, indexTeamMembers); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), obj, teamDirectoryIndex, indexTeamDirectory); err != nil {
+		return err
+	}
+
+	return mgr.GetFieldIndexer().IndexField(context.Background(), obj, teamDNIndex, indexTeamDN)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *TeamReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := r.setupIndexes(mgr, &appv1.Team{}); err != nil {
+		return err
+	}
+
+	if err := registerResourceCollector(mgr.GetClient(), r.PerResourceMetrics); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1.Directory{}, directorySecretIndex, indexDirectorySecrets); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&appv1.Team{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(&source.Kind{Type: &appv1.Team{}}, handler.EnqueueRequestsFromMapFunc(r.teamsIncluding)).
+		Watches(&source.Kind{Type: &appv1.Directory{}}, handler.EnqueueRequestsFromMapFunc(r.teamsUsing(false))).
+		Watches(&source.Kind{Type: &v1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.teamsUsingSecret(false)), builder.OnlyMetadata).
 		Complete(r)
 }
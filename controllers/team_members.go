@@ -0,0 +1,215 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/backend"
+	"github.com/vbouchaud/wellerman/internal/ldap"
+)
+
+// teamMemberIndex indexes Teams by the name of the Teams they include.
+const teamMemberIndex = "spec.members.team"
+
+func indexTeamMembers(obj client.Object) []string {
+	var names []string
+
+	for _, member := range obj.(appv1.TeamObject).GetSpec().Members {
+		if member.Team != "" {
+			names = append(names, member.Team)
+		}
+	}
+
+	return names
+}
+
+// teamsIncluding enqueues the Teams, or ClusterTeams, that include the given
+// one as a member.
+func (r *TeamReconciler) teamsIncluding(obj client.Object) []reconcile.Request {
+	_, cluster := obj.(*appv1.ClusterTeam)
+
+	teams, err := r.listTeams(context.Background(), cluster, client.InNamespace(obj.GetNamespace()), client.MatchingFields{teamMemberIndex: obj.GetName()})
+	if err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(teams))
+	for _, team := range teams {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(team)})
+	}
+
+	return requests
+}
+
+// memberResolver turns the subjects and members of a Team into distinguished
+// names. Subjects that cannot be resolved are collected rather than failing
+// the whole resolution.
+type memberResolver struct {
+	client.Client
+	ldap GroupDirectory
+	// ldapFor returns the directory of the nested Teams set on another
+	// Directory than the Team including them.
+	ldapFor func(ctx context.Context, team appv1.TeamObject) (GroupDirectory, error)
+
+	dns        []string
+	seen       map[string]bool
+	visited    map[string]bool
+	unresolved []string
+
+	// statuses are the outcome of the resolution of the members the Team
+	// declares itself.
+	statuses []appv1.TeamMemberStatus
+
+	// dynamic is set when a member filter was evaluated, the result can then
+	// change without the Team being edited.
+	dynamic bool
+}
+
+func (r *TeamReconciler) resolveMembers(ctx context.Context, team appv1.TeamObject, directory GroupDirectory) (*memberResolver, error) {
+	resolver := &memberResolver{
+		Client:  r.Client,
+		ldap:    directory,
+		ldapFor: r.ldapFor,
+		seen:    map[string]bool{},
+		visited: map[string]bool{team.GetName(): true},
+	}
+
+	if err := resolver.resolve(ctx, team); err != nil {
+		return nil, err
+	}
+
+	return resolver, nil
+}
+
+func (m *memberResolver) add(dn string) {
+	if key := strings.ToLower(dn); !m.seen[key] {
+		m.seen[key] = true
+		m.dns = append(m.dns, dn)
+	}
+}
+
+// resolve adds the members of team. A login or email that no longer resolves
+// keeps the DNs it resolved to last time, as recorded in the status of team,
+// rather than have the person silently removed from the group.
+func (m *memberResolver) resolve(ctx context.Context, team appv1.TeamObject) error {
+	spec := team.GetSpec()
+	top := len(m.visited) == 1
+
+	previous := map[string][]string{}
+	for _, memberStatus := range team.GetStatus().MemberStatuses {
+		previous[memberStatus.Member] = memberStatus.DNs
+	}
+
+	for _, subject := range spec.Subjects {
+		m.add(subject)
+	}
+
+	for _, member := range spec.Members {
+		var (
+			dn    string
+			err   error
+			state = appv1.MemberResolved
+		)
+
+		switch {
+		case member.DN != "":
+			dn = member.DN
+		case member.User != "":
+			dn, err = m.ldap.ResolveUser(ctx, member.User)
+		case member.Email != "":
+			dn, err = m.ldap.ResolveEmail(ctx, member.Email)
+		case member.Team != "":
+			state, err = m.resolveTeam(ctx, team, member)
+		}
+
+		var dns []string
+		if err != nil {
+			if !ldap.IsNotFound(err) {
+				return err
+			}
+			state = appv1.MemberNotFound
+		} else if dn != "" {
+			dns = []string{dn}
+		}
+
+		switch {
+		case state == appv1.MemberNotFound && len(previous[member.String()]) > 0:
+			dns = previous[member.String()]
+			m.unresolved = append(m.unresolved, fmt.Sprintf("%s (not found, keeping %s)", member, strings.Join(dns, "; ")))
+		case state == appv1.MemberNotFound:
+			m.unresolved = append(m.unresolved, fmt.Sprintf("%s (not found)", member))
+		case state == appv1.MemberCycle:
+			m.unresolved = append(m.unresolved, fmt.Sprintf("%s (cycle)", member))
+		}
+
+		for _, dn := range dns {
+			m.add(dn)
+		}
+
+		if top {
+			m.statuses = append(m.statuses, appv1.TeamMemberStatus{Member: member.String(), State: state, DNs: dns})
+		}
+	}
+
+	if spec.MemberFilter != "" {
+		dns, err := m.ldap.SearchUsers(ctx, spec.MemberFilter)
+		if err != nil {
+			return err
+		}
+
+		for _, dn := range dns {
+			m.add(dn)
+		}
+		m.dynamic = true
+	}
+
+	return nil
+}
+
+// resolveTeam adds the members of the Team member of team, resolved against
+// the Directory of that Team. Members of a Team whose Directory is unavailable
+// are not found.
+func (m *memberResolver) resolveTeam(ctx context.Context, team appv1.TeamObject, member appv1.TeamMember) (appv1.MemberState, error) {
+	if m.visited[member.Team] {
+		return appv1.MemberCycle, nil
+	}
+
+	namespace := team.GetNamespace()
+	nested := newTeamObject(namespace == "")
+	if err := m.Get(ctx, types.NamespacedName{Namespace: namespace, Name: member.Team}, nested); err != nil {
+		if errors.IsNotFound(err) {
+			return appv1.MemberNotFound, nil
+		}
+		return "", err
+	}
+
+	if nested.GetSpec().Directory != team.GetSpec().Directory {
+		directory, err := m.ldapFor(ctx, nested)
+		if errors.IsNotFound(err) || backend.IsTerminal(err) {
+			return appv1.MemberNotFound, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		including := m.ldap
+		m.ldap = directory
+		defer func() { m.ldap = including }()
+	}
+
+	m.visited[member.Team] = true
+	defer delete(m.visited, member.Team)
+
+	return appv1.MemberResolved, m.resolve(ctx, nested)
+}
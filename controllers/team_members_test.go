@@ -0,0 +1,212 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/backend"
+)
+
+var _ = Describe("memberResolver", func() {
+	const (
+		jdoe   = "uid=jdoe,ou=people,dc=example,dc=org"
+		asmith = "uid=asmith,ou=people,dc=example,dc=org"
+		bking  = "uid=bking,ou=people,dc=example,dc=org"
+	)
+
+	var directory *fakeDirectory
+
+	BeforeEach(func() {
+		directory = newFakeDirectory()
+		directory.users["jdoe"] = jdoe
+		directory.users["asmith@example.org"] = asmith
+	})
+
+	newTeam := func(name string, spec appv1.TeamSpec) *appv1.Team {
+		return &appv1.Team{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Spec: spec}
+	}
+
+	resolve := func(team *appv1.Team, others ...client.Object) *memberResolver {
+		r := &TeamReconciler{Client: newFakeClient(others...)}
+		resolver, err := r.resolveMembers(ctx, team, directory)
+		Expect(err).NotTo(HaveOccurred())
+		return resolver
+	}
+
+	It("resolves subjects, logins, emails and filters once each", func() {
+		directory.filters["(department=sre)"] = []string{jdoe, bking}
+
+		resolver := resolve(newTeam("sre", appv1.TeamSpec{
+			Subjects:     []string{jdoe},
+			Members:      []appv1.TeamMember{{User: "jdoe"}, {Email: "asmith@example.org"}},
+			MemberFilter: "(department=sre)",
+		}))
+
+		Expect(resolver.dns).To(Equal([]string{jdoe, asmith, bking}))
+		Expect(resolver.unresolved).To(BeEmpty())
+		Expect(resolver.dynamic).To(BeTrue())
+		Expect(resolver.statuses).To(Equal([]appv1.TeamMemberStatus{
+			{Member: "user: jdoe", State: appv1.MemberResolved, DNs: []string{jdoe}},
+			{Member: "email: asmith@example.org", State: appv1.MemberResolved, DNs: []string{asmith}},
+		}))
+	})
+
+	It("includes the members of nested Teams", func() {
+		nested := newTeam("dba", appv1.TeamSpec{Subjects: []string{bking}, Members: []appv1.TeamMember{{User: "jdoe"}}})
+
+		resolver := resolve(newTeam("ops", appv1.TeamSpec{
+			Members: []appv1.TeamMember{{Team: "dba"}, {Email: "asmith@example.org"}},
+		}), nested)
+
+		Expect(resolver.dns).To(Equal([]string{bking, jdoe, asmith}))
+		Expect(resolver.unresolved).To(BeEmpty())
+		Expect(resolver.statuses[0]).To(Equal(appv1.TeamMemberStatus{Member: "team: dba", State: appv1.MemberResolved}))
+	})
+
+	It("resolves nested Teams against their own Directory", func() {
+		other := newFakeDirectory()
+		other.users["jdoe"] = "uid=jdoe,ou=people,dc=other,dc=org"
+		nested := newTeam("dba", appv1.TeamSpec{Directory: "other", Members: []appv1.TeamMember{{User: "jdoe"}}})
+
+		resolver := &memberResolver{
+			Client: newFakeClient(nested),
+			ldap:   directory,
+			ldapFor: func(ctx context.Context, team appv1.TeamObject) (GroupDirectory, error) {
+				Expect(team.GetSpec().Directory).To(Equal("other"))
+				return other, nil
+			},
+			seen:    map[string]bool{},
+			visited: map[string]bool{"ops": true},
+		}
+		Expect(resolver.resolve(ctx, newTeam("ops", appv1.TeamSpec{
+			Members: []appv1.TeamMember{{Team: "dba"}, {User: "jdoe"}},
+		}))).To(Succeed())
+
+		Expect(resolver.dns).To(Equal([]string{"uid=jdoe,ou=people,dc=other,dc=org", jdoe}))
+	})
+
+	It("reports nested Teams whose Directory is missing", func() {
+		nested := newTeam("dba", appv1.TeamSpec{Directory: "missing", Subjects: []string{bking}})
+
+		resolver := resolve(newTeam("ops", appv1.TeamSpec{
+			Subjects: []string{jdoe},
+			Members:  []appv1.TeamMember{{Team: "dba"}},
+		}), nested)
+
+		Expect(resolver.dns).To(Equal([]string{jdoe}))
+		Expect(resolver.unresolved).To(ConsistOf("team: dba (not found)"))
+	})
+
+	It("reports cycles between Teams and still resolves the other members", func() {
+		a := newTeam("a", appv1.TeamSpec{Subjects: []string{jdoe}, Members: []appv1.TeamMember{{Team: "b"}}})
+		b := newTeam("b", appv1.TeamSpec{Subjects: []string{bking}, Members: []appv1.TeamMember{{Team: "a"}}})
+
+		resolver := resolve(a, b)
+
+		Expect(resolver.dns).To(Equal([]string{jdoe, bking}))
+		Expect(resolver.unresolved).To(ConsistOf("team: a (cycle)"))
+	})
+
+	It("reports missing nested Teams", func() {
+		resolver := resolve(newTeam("ops", appv1.TeamSpec{
+			Subjects: []string{jdoe},
+			Members:  []appv1.TeamMember{{Team: "missing"}},
+		}))
+
+		Expect(resolver.dns).To(Equal([]string{jdoe}))
+		Expect(resolver.unresolved).To(ConsistOf("team: missing (not found)"))
+		Expect(resolver.statuses).To(Equal([]appv1.TeamMemberStatus{{Member: "team: missing", State: appv1.MemberNotFound}}))
+	})
+
+	It("reports users that are not found", func() {
+		resolver := resolve(newTeam("ops", appv1.TeamSpec{Members: []appv1.TeamMember{{User: "nobody"}}}))
+
+		Expect(resolver.dns).To(BeEmpty())
+		Expect(resolver.unresolved).To(ConsistOf("user: nobody (not found)"))
+	})
+
+	It("keeps the previous DN of users that are no longer found", func() {
+		team := newTeam("ops", appv1.TeamSpec{Members: []appv1.TeamMember{{User: "gone"}, {User: "jdoe"}}})
+		team.Status.MemberStatuses = []appv1.TeamMemberStatus{
+			{Member: "user: gone", State: appv1.MemberResolved, DNs: []string{bking}},
+		}
+
+		resolver := resolve(team)
+
+		Expect(resolver.dns).To(Equal([]string{bking, jdoe}))
+		Expect(resolver.unresolved).To(ConsistOf("user: gone (not found, keeping " + bking + ")"))
+		Expect(resolver.statuses[0]).To(Equal(appv1.TeamMemberStatus{Member: "user: gone", State: appv1.MemberNotFound, DNs: []string{bking}}))
+	})
+
+	It("keeps the previous DN of users of nested Teams that are no longer found", func() {
+		nested := newTeam("dba", appv1.TeamSpec{Members: []appv1.TeamMember{{User: "gone"}}})
+		nested.Status.MemberStatuses = []appv1.TeamMemberStatus{
+			{Member: "user: gone", State: appv1.MemberResolved, DNs: []string{bking}},
+		}
+
+		resolver := resolve(newTeam("ops", appv1.TeamSpec{Members: []appv1.TeamMember{{Team: "dba"}}}), nested)
+
+		Expect(resolver.dns).To(Equal([]string{bking}))
+	})
+})
+
+var _ = Describe("Team reconciliation", func() {
+	It("leaves the group unchanged when no member resolves", func() {
+		directory := newFakeDirectory()
+		dn := "cn=ops,ou=groups,dc=example,dc=org"
+		directory.groups[dn] = []string{"uid=jdoe,ou=people,dc=example,dc=org"}
+
+		team := &appv1.Team{
+			ObjectMeta: metav1.ObjectMeta{Name: "ops", Namespace: "default"},
+			Spec:       appv1.TeamSpec{Members: []appv1.TeamMember{{User: "nobody"}}},
+		}
+		k8s := newFakeClient(team)
+		r := &TeamReconciler{Client: k8s, Recorder: newRecorder(), Ldap: directory}
+
+		_, err := r.Reconcile(ctx, requestFor(team))
+		Expect(err).To(MatchError(errNoMembers))
+		Expect(directory.Writes()).To(BeEmpty())
+
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(team), team)).To(Succeed())
+		Expect(team.Status.Conditions).To(ContainElement(And(
+			HaveField("Type", conditionSynced),
+			HaveField("Reason", "NoMembers"),
+		)))
+	})
+
+	It("records the members it adds before adding them with the Additive policy", func() {
+		directory := newFakeDirectory()
+		directory.users["jdoe"] = "uid=jdoe,ou=people,dc=example,dc=org"
+		directory.errs["ReconcileGroup"] = backend.New(backend.Transient, "connection reset")
+
+		team := &appv1.Team{
+			ObjectMeta: metav1.ObjectMeta{Name: "ops", Namespace: "default"},
+			Spec: appv1.TeamSpec{
+				Members:          []appv1.TeamMember{{User: "jdoe"}},
+				MembershipPolicy: appv1.MembershipAdditive,
+			},
+			Status: appv1.TeamStatus{Members: []string{"uid=asmith,ou=people,dc=example,dc=org"}},
+		}
+		k8s := newFakeClient(team)
+		r := &TeamReconciler{Client: k8s, Recorder: newRecorder(), Ldap: directory}
+
+		_, err := r.Reconcile(ctx, requestFor(team))
+		Expect(err).To(HaveOccurred())
+
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(team), team)).To(Succeed())
+		Expect(team.Status.Members).To(Equal([]string{
+			"uid=asmith,ou=people,dc=example,dc=org",
+			"uid=jdoe,ou=people,dc=example,dc=org",
+		}))
+	})
+})
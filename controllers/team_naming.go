@@ -0,0 +1,230 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+)
+
+const (
+	// NamingStrategyName names the group of a Team after the Team only. Teams
+	// of the same name in different namespaces then compete for one group,
+	// which stays with the first one to claim it while the others report a
+	// GroupNameTaken conflict.
+	NamingStrategyName = "name"
+	// NamingStrategyNamespacePrefix names the group of a Team <namespace>-<name>.
+	NamingStrategyNamespacePrefix = "namespace-prefix"
+)
+
+// teamDNIndex indexes Teams and ClusterTeams by the lower cased DN of the group
+// they manage.
+const teamDNIndex = "status.dn"
+
+func indexTeamDN(obj client.Object) []string {
+	if dn := obj.(appv1.TeamObject).GetStatus().DistinguishedName; dn != "" {
+		return []string{strings.ToLower(dn)}
+	}
+	return nil
+}
+
+func newTeamObject(cluster bool) appv1.TeamObject {
+	if cluster {
+		return &appv1.ClusterTeam{}
+	}
+	return &appv1.Team{}
+}
+
+func (r *TeamReconciler) listTeams(ctx context.Context, cluster bool, opts ...client.ListOption) ([]appv1.TeamObject, error) {
+	var teams []appv1.TeamObject
+
+	if cluster {
+		list := &appv1.ClusterTeamList{}
+		if err := r.List(ctx, list, opts...); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			teams = append(teams, &list.Items[i])
+		}
+	} else {
+		list := &appv1.TeamList{}
+		if err := r.List(ctx, list, opts...); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			teams = append(teams, &list.Items[i])
+		}
+	}
+
+	return teams, nil
+}
+
+// groupName returns the name of the group of the Team: its explicit group name,
+// or a name derived from the Team according to the naming strategy.
+func (r *TeamReconciler) groupName(team appv1.TeamObject) string {
+	if name := team.GetSpec().GroupName; name != "" {
+		return name
+	}
+
+	if team.GetNamespace() != "" && r.NamingStrategy == NamingStrategyNamespacePrefix {
+		return team.GetNamespace() + "-" + team.GetName()
+	}
+
+	return team.GetName()
+}
+
+// claimedBy returns the Team or ClusterTeam that manages the group at dn, in
+// the same Directory, or that claimed it while being reconciled, in which case
+// team must leave the group alone.
+func (r *TeamReconciler) claimedBy(ctx context.Context, team appv1.TeamObject, dn string) (appv1.TeamObject, error) {
+	other, err := r.managedBy(ctx, team, dn)
+	if err != nil || other != nil {
+		return other, err
+	}
+
+	return r.claimant(ctx, team, dn, false)
+}
+
+// claimGroup claims the group at dn for team, unless another Team manages or
+// claimed it, which is then returned. The claim is atomic, for two Teams
+// reconciled at once not to both write the group before either recorded it
+// in its status.
+func (r *TeamReconciler) claimGroup(ctx context.Context, team appv1.TeamObject, dn string) (appv1.TeamObject, error) {
+	other, err := r.managedBy(ctx, team, dn)
+	if err != nil || other != nil {
+		return other, err
+	}
+
+	return r.claimant(ctx, team, dn, true)
+}
+
+// managedBy returns the Team or ClusterTeam recording dn in its status, in the
+// same Directory, that takes precedence over team. A Team that does not
+// record dn yet never takes precedence over one that does; among Teams that
+// both record it, the oldest one does, then the first by namespace and name.
+func (r *TeamReconciler) managedBy(ctx context.Context, team appv1.TeamObject, dn string) (appv1.TeamObject, error) {
+	recorded := strings.EqualFold(team.GetStatus().DistinguishedName, dn)
+
+	for _, cluster := range []bool{false, true} {
+		teams, err := r.listTeams(ctx, cluster, client.MatchingFields{teamDNIndex: strings.ToLower(dn)})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, other := range teams {
+			if other.GetUID() == team.GetUID() || other.GetSpec().Directory != team.GetSpec().Directory {
+				continue
+			}
+			if !recorded || precedes(other, team) {
+				return other, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// claimant returns the Team that claimed the group at dn before team, when it
+// still exists. With take, team claims the group when no other Team did.
+func (r *TeamReconciler) claimant(ctx context.Context, team appv1.TeamObject, dn string, take bool) (appv1.TeamObject, error) {
+	key := team.GetSpec().Directory + "/" + strings.ToLower(dn)
+	recorded := strings.EqualFold(team.GetStatus().DistinguishedName, dn)
+
+	for {
+		holder, free := r.claims.take(key, claimOf(team), take, recorded)
+		if free {
+			return nil, nil
+		}
+
+		other := newTeamObject(holder.cluster)
+		err := r.Get(ctx, holder.key, other)
+		if errors.IsNotFound(err) || (err == nil && other.GetUID() != holder.uid) {
+			r.claims.release(holder.uid)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return other, nil
+	}
+}
+
+func precedes(a, b appv1.TeamObject) bool {
+	at, bt := a.GetCreationTimestamp(), b.GetCreationTimestamp()
+	if !at.Equal(&bt) {
+		return at.Before(&bt)
+	}
+
+	return client.ObjectKeyFromObject(a).String() < client.ObjectKeyFromObject(b).String()
+}
+
+// claim is a Team holding the claim on a group.
+type claim struct {
+	uid     types.UID
+	key     client.ObjectKey
+	cluster bool
+}
+
+func claimOf(team appv1.TeamObject) claim {
+	_, cluster := team.(*appv1.ClusterTeam)
+	return claim{uid: team.GetUID(), key: client.ObjectKeyFromObject(team), cluster: cluster}
+}
+
+// groupClaims holds the groups claimed by the Teams and ClusterTeams being
+// reconciled, keyed by Directory and lower cased DN. The status.dn index only
+// sees a group once the Team writing it recorded it in its status, claims
+// cover the time in between. A Team holds one claim at most.
+type groupClaims struct {
+	mu      sync.Mutex
+	holders map[string]claim
+	held    map[types.UID]string
+}
+
+// take returns the claim held on key by another Team, or true when there is
+// none. With take, the claim on key is then given to team, which releases its
+// previous one; with force, it is given even when another Team holds it.
+func (c *groupClaims) take(key string, team claim, take, force bool) (claim, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if holder, ok := c.holders[key]; ok && holder.uid != team.uid && !force {
+		return holder, false
+	}
+	if !take {
+		return claim{}, true
+	}
+
+	if c.holders == nil {
+		c.holders, c.held = map[string]claim{}, map[types.UID]string{}
+	}
+	if previous, ok := c.held[team.uid]; ok && previous != key {
+		delete(c.holders, previous)
+	}
+	if holder, ok := c.holders[key]; ok && holder.uid != team.uid {
+		delete(c.held, holder.uid)
+	}
+	c.holders[key], c.held[team.uid] = team, key
+
+	return claim{}, true
+}
+
+// release drops the claim held by the Team of uid, if any.
+func (c *groupClaims) release(uid types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.held[uid]; ok {
+		delete(c.holders, key)
+		delete(c.held, uid)
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2023.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+)
+
+type ctrlResult struct {
+	result ctrl.Result
+	err    error
+}
+
+var _ = Describe("Group claims", func() {
+	const (
+		memberDN = "uid=jdoe,ou=people,dc=example,dc=org"
+		opsDN    = "cn=ops,ou=groups,dc=example,dc=org"
+	)
+
+	var (
+		k8s       client.Client
+		directory *fakeDirectory
+		teams     *TeamReconciler
+	)
+
+	newTeam := func(namespace string, created time.Time) *appv1.Team {
+		return &appv1.Team{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "ops",
+				Namespace:         namespace,
+				UID:               types.UID(namespace + "/ops"),
+				CreationTimestamp: metav1.NewTime(created),
+				Finalizers:        []string{teamFinalizer.name},
+			},
+			Spec: appv1.TeamSpec{Subjects: []string{memberDN}},
+		}
+	}
+
+	conflictOf := func(team *appv1.Team) *metav1.Condition {
+		Expect(k8s.Get(ctx, client.ObjectKeyFromObject(team), team)).To(Succeed())
+		return meta.FindStatusCondition(team.Status.Conditions, conditionConflict)
+	}
+
+	BeforeEach(func() {
+		k8s = newFakeClient()
+		directory = newFakeDirectory()
+		teams = &TeamReconciler{Client: k8s, Scheme: scheme.Scheme, Recorder: newRecorder(), Ldap: directory}
+	})
+
+	It("gives the group to one of two Teams reconciled at once", func() {
+		now := time.Now()
+		first, second := newTeam("first", now), newTeam("second", now.Add(-time.Hour))
+		Expect(k8s.Create(ctx, first)).To(Succeed())
+		Expect(k8s.Create(ctx, second)).To(Succeed())
+
+		directory.hold, directory.entered = make(chan struct{}), make(chan string, 2)
+		DeferCleanup(func() {
+			select {
+			case <-directory.hold:
+			default:
+				close(directory.hold)
+			}
+		})
+		reconcile := func(team *appv1.Team) chan ctrlResult {
+			done := make(chan ctrlResult, 1)
+			go func() {
+				result, err := teams.Reconcile(ctx, requestFor(team))
+				done <- ctrlResult{result, err}
+			}()
+			return done
+		}
+
+		firstDone := reconcile(first)
+		Eventually(directory.entered).Should(Receive(Equal("ops")))
+
+		var secondResult ctrlResult
+		Eventually(reconcile(second)).Should(Receive(&secondResult))
+		Expect(secondResult.err).NotTo(HaveOccurred())
+		Expect(secondResult.result.RequeueAfter).To(Equal(conflictRequeueDelay))
+
+		close(directory.hold)
+		var firstResult ctrlResult
+		Eventually(firstDone).Should(Receive(&firstResult))
+		Expect(firstResult.err).NotTo(HaveOccurred())
+
+		Expect(directory.Writes()).To(Equal([]string{"reconcile " + opsDN}))
+		Expect(conflictOf(first)).To(BeNil())
+		Expect(first.Status.DistinguishedName).To(Equal(opsDN))
+		Expect(conflictOf(second).Reason).To(Equal("GroupNameTaken"))
+		Expect(second.Status.DistinguishedName).To(BeEmpty())
+	})
+
+	It("keeps the group with the Team recording it, even when it is newer", func() {
+		now := time.Now()
+		recorded := newTeam("recorded", now)
+		recorded.Status.DistinguishedName = opsDN
+		older := newTeam("older", now.Add(-time.Hour))
+		Expect(k8s.Create(ctx, recorded)).To(Succeed())
+		Expect(k8s.Create(ctx, older)).To(Succeed())
+
+		_, err := teams.Reconcile(ctx, requestFor(older))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflictOf(older).Reason).To(Equal("GroupNameTaken"))
+
+		_, err = teams.Reconcile(ctx, requestFor(recorded))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflictOf(recorded)).To(BeNil())
+		Expect(directory.Writes()).To(Equal([]string{"reconcile " + opsDN}))
+	})
+
+	It("releases the claim of a deleted Team", func() {
+		now := time.Now()
+		first, second := newTeam("first", now), newTeam("second", now)
+		Expect(k8s.Create(ctx, first)).To(Succeed())
+		Expect(k8s.Create(ctx, second)).To(Succeed())
+
+		_, err := teams.Reconcile(ctx, requestFor(first))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = teams.Reconcile(ctx, requestFor(second))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflictOf(second).Reason).To(Equal("GroupNameTaken"))
+
+		Expect(k8s.Delete(ctx, first)).To(Succeed())
+		_, err = teams.Reconcile(ctx, requestFor(first))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = teams.Reconcile(ctx, requestFor(second))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflictOf(second)).To(BeNil())
+		Expect(second.Status.DistinguishedName).To(Equal(opsDN))
+	})
+
+	It("looks for the group of a Team without recorded DN where it was created before the DN template", func() {
+		team := newTeam("legacy", time.Now())
+		Expect(k8s.Create(ctx, team)).To(Succeed())
+
+		_, err := teams.Reconcile(ctx, requestFor(team))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(directory.previous).To(Equal([]string{"cn=ops,ou=legacy,dc=example,dc=org"}))
+	})
+
+	It("does not look for a legacy group after losing the group to another Team", func() {
+		team := newTeam("lost", time.Now())
+		team.Spec.GroupName = "renamed"
+		Expect(k8s.Create(ctx, team)).To(Succeed())
+		team.Status.Conditions = []metav1.Condition{{
+			Type:               conditionConflict,
+			Status:             metav1.ConditionTrue,
+			Reason:             "GroupNameTaken",
+			LastTransitionTime: metav1.Now(),
+		}}
+		Expect(k8s.Status().Update(ctx, team)).To(Succeed())
+
+		_, err := teams.Reconcile(ctx, requestFor(team))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(directory.previous).To(Equal([]string{""}))
+		Expect(directory.Writes()).To(Equal([]string{"reconcile cn=renamed,ou=groups,dc=example,dc=org"}))
+	})
+})
@@ -0,0 +1,116 @@
+// Package backend holds what the GitLab and LDAP clients share, such as the
+// classification of their errors.
+package backend
+
+import (
+	"errors"
+	"time"
+)
+
+// Kind classifies the errors of the backends by how they are to be retried.
+type Kind int
+
+const (
+	// Unknown is any error that was not classified, retried with backoff.
+	Unknown Kind = iota
+	// NotFound is an object missing on the backend.
+	NotFound
+	// Conflict is an object that exists already, or is held by someone else.
+	Conflict
+	// Unauthorized is a refused authentication or permission.
+	Unauthorized
+	// RateLimited is a request refused until the backend is less loaded.
+	RateLimited
+	// Transient is a failure of the network or of the backend, that may not
+	// happen again.
+	Transient
+	// Invalid is a request that the backend will refuse until it changes.
+	Invalid
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NotFound:
+		return "NotFound"
+	case Conflict:
+		return "Conflict"
+	case Unauthorized:
+		return "Unauthorized"
+	case RateLimited:
+		return "RateLimited"
+	case Transient:
+		return "Transient"
+	case Invalid:
+		return "Invalid"
+	default:
+		return "Unknown"
+	}
+}
+
+// Error is an error of a backend along with its Kind.
+type Error struct {
+	Kind Kind
+	// Op describes what was attempted, it prefixes the message of Err.
+	Op  string
+	Err error
+	// RetryAfter is the delay asked by a RateLimited answer, if any.
+	RetryAfter time.Duration
+}
+
+// New returns an Error of the given kind with message as its text.
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Err: errors.New(message)}
+}
+
+// Wrap returns err classified as kind, its message prefixed with op when set.
+func Wrap(kind Kind, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the Kind of the first Error err wraps, Unknown when none.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Unknown
+}
+
+// RetryAfterOf returns the delay asked by the backend before retrying, 0 when
+// it did not ask for any.
+func RetryAfterOf(err error) time.Duration {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.RetryAfter
+	}
+	return 0
+}
+
+// IsNotFound tells whether err is, or wraps, a NotFound Error.
+func IsNotFound(err error) bool {
+	return KindOf(err) == NotFound
+}
+
+// IsTerminal tells whether retrying err is pointless until the request, or
+// the credentials it is made with, change.
+func IsTerminal(err error) bool {
+	switch KindOf(err) {
+	case Unauthorized, Invalid:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package backend
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wellerman_backend_requests_total",
+		Help: "Number of requests sent to the backends, by backend, operation and result.",
+	}, []string{"backend", "operation", "result"})
+
+	requestDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wellerman_backend_request_duration_seconds",
+		Help:    "Time the backends took to answer requests, by backend and operation.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"backend", "operation"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(requests, requestDurationSeconds)
+}
+
+// Observe records a request for operation sent to backend at start, that
+// ended with err.
+func Observe(backend, operation string, start time.Time, err error) {
+	requestDurationSeconds.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	requests.WithLabelValues(backend, operation, Result(err)).Inc()
+}
+
+// Result is "success" when err is nil, the Kind of err otherwise.
+func Result(err error) string {
+	if err == nil {
+		return "success"
+	}
+	return KindOf(err).String()
+}
@@ -0,0 +1,105 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const DefaultFilePollInterval = 30 * time.Second
+
+// FileWatcher polls a file holding a credential, such as a mounted Secret
+// key, and hands its content over whenever it changes.
+type FileWatcher struct {
+	Name     string
+	Path     string
+	Interval time.Duration
+	Registry *Registry
+
+	// OnChange receives the new content of the file, trailing newlines
+	// removed.
+	OnChange func(string) error
+
+	content []byte
+}
+
+// Start polls the file until ctx is done. It implements manager.Runnable.
+func (f *FileWatcher) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("credentials", f.Name, "path", f.Path)
+
+	interval := f.Interval
+	if interval <= 0 {
+		interval = DefaultFilePollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.poll(); err != nil {
+			logger.Error(err, "Failed to reload credentials.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Load reads the file once and records it, without calling OnChange.
+func (f *FileWatcher) Load() (string, error) {
+	content, info, err := f.read()
+	if err != nil {
+		return "", err
+	}
+
+	f.content = content
+	f.Registry.Record(f.Name, f.Path, info.ModTime().UTC().Format(time.RFC3339Nano))
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func (f *FileWatcher) poll() error {
+	content, info, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	if f.content != nil && bytes.Equal(content, f.content) {
+		return nil
+	}
+
+	if err = f.OnChange(strings.TrimRight(string(content), "\r\n")); err != nil {
+		return err
+	}
+
+	f.content = content
+	f.Registry.Record(f.Name, f.Path, info.ModTime().UTC().Format(time.RFC3339Nano))
+
+	return nil
+}
+
+func (f *FileWatcher) read() ([]byte, os.FileInfo, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return content, info, nil
+}
+
+// NeedLeaderElection lets every replica keep its credentials up to date.
+func (f *FileWatcher) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,67 @@
+package credentials
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Source describes where a credential in use was read from, never the
+// credential itself.
+type Source struct {
+	// Name identifies what uses the credential, such as "ldap" or
+	// "directory/corporate".
+	Name string `json:"name"`
+	// From is the file or the namespace/name of the Secret it was read from.
+	From string `json:"from"`
+	// Version is the resourceVersion of the Secret, or the modification time
+	// of the file.
+	Version  string    `json:"version"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+// Registry records the credentials the backends currently use, so that a
+// rotation can be confirmed from its debug endpoint.
+type Registry struct {
+	mu      sync.RWMutex
+	sources map[string]Source
+}
+
+func NewRegistry() *Registry {
+	return &Registry{sources: map[string]Source{}}
+}
+
+// Record sets the source of the credentials used by name. A nil registry
+// records nothing.
+func (r *Registry) Record(name, from, version string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sources[name] = Source{Name: name, From: from, Version: version, LoadedAt: time.Now()}
+}
+
+// Sources returns the recorded sources, sorted by name.
+func (r *Registry) Sources() []Source {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sources := make([]Source, 0, len(r.sources))
+	for _, source := range r.sources {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+
+	return sources
+}
+
+// ServeHTTP lists the recorded sources as JSON.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(r.Sources())
+}
@@ -0,0 +1,28 @@
+// Package dn escapes the values of distinguished names, for the webhooks to
+// normalise DNs as the LDAP client writes them.
+package dn
+
+import "strings"
+
+// EscapeValue escapes value to be used as the value of an RDN, as RFC 4514
+// requires.
+func EscapeValue(value string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == 0:
+			b.WriteString(`\00`)
+		case strings.IndexByte(`"+,;<>\=`, c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
@@ -1,15 +1,26 @@
 package gitlab
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"path"
+	"strings"
+	"time"
 
 	git "github.com/xanzy/go-gitlab"
 
 	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/backend"
 )
 
+// Change is a write wellerman performed on GitLab, for it to be reported.
+type Change struct {
+	// Reason is a short CamelCase name of the change, such as ProjectCreated.
+	Reason string
+	// Message describes the change and names its target.
+	Message string
+}
+
 func findInGroups(p string, groups []*git.Group) *git.Group {
 	for _, group := range groups {
 		if group.FullPath == p {
@@ -19,15 +30,29 @@ func findInGroups(p string, groups []*git.Group) *git.Group {
 	return nil
 }
 
-func (s *Client) FindProjects(p appv1.ProjectPath) (*git.Project, error) {
+// FindProjects returns the project at p, nil when there is none. A remembered
+// id is checked to still lead to p before it is trusted. The project itself is
+// always read from GitLab for drift to be seen, a remembered id only turning
+// the search among every project of that name into a single GET by id.
+func (s *Client) FindProjects(ctx context.Context, p appv1.ProjectPath) (*git.Project, error) {
+	if id, ok := s.projects.get(p.Path); ok {
+		project, _, err := s.git().Projects.GetProject(id, nil, git.WithContext(ctx))
+		if err = wrap("Could not get project", err); err == nil && project.PathWithNamespace == p.Path {
+			return project, nil
+		} else if err != nil && !backend.IsNotFound(err) {
+			return nil, err
+		}
+		s.projects.drop(p.Path)
+	}
 
-	projects, _, err := s.c.Projects.ListProjects(&git.ListProjectsOptions{Search: git.String(path.Base(p.Path))})
+	projects, _, err := s.git().Projects.ListProjects(&git.ListProjectsOptions{Search: git.String(path.Base(p.Path))}, git.WithContext(ctx))
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Could not list projects: %s", err.Error()))
+		return nil, wrap("Could not list projects", err)
 	}
 
 	for _, project := range projects {
 		if project.PathWithNamespace == p.Path {
+			s.projects.set(p.Path, project.ID)
 			return project, nil
 		}
 	}
@@ -35,25 +60,34 @@ func (s *Client) FindProjects(p appv1.ProjectPath) (*git.Project, error) {
 	return nil, nil
 }
 
-func (s *Client) ensurePathExists(p string) (int, error) {
-	groups, _, err := s.c.Groups.ListGroups(&git.ListGroupsOptions{Search: git.String(p)})
+// ensurePathExists creates the groups missing along p and returns the id of
+// the last one. With dryRun, missing groups are reported but not created and
+// -1 is returned in their place.
+func (s *Client) ensurePathExists(ctx context.Context, p string, dryRun bool) (int, error, []Change) {
+	if id, ok := s.groups.get(p); ok {
+		return id, nil, nil
+	}
+
+	groups, _, err := s.git().Groups.ListGroups(&git.ListGroupsOptions{Search: git.String(p)}, git.WithContext(ctx))
 	if err != nil {
-		return -1, errors.New(fmt.Sprintf("Could not list group: %s", err.Error()))
+		return -1, wrap("Could not list group", err), nil
 	}
 
 	if group := findInGroups(p, groups); group != nil {
-		return group.ID, nil
+		s.groups.set(p, group.ID)
+		return group.ID, nil, nil
 	}
 
 	var (
 		newPath  = path.Dir(p)
 		parentId = -1
+		changes  []Change
 	)
 
 	if newPath != "." {
-		parentId, err = s.ensurePathExists(newPath)
+		parentId, err, changes = s.ensurePathExists(ctx, newPath, dryRun)
 		if err != nil {
-			return parentId, err
+			return parentId, err, changes
 		}
 	}
 
@@ -62,73 +96,208 @@ func (s *Client) ensurePathExists(p string) (int, error) {
 	groupOptions := &git.CreateGroupOptions{
 		Name:       git.String(path.Base(p)),
 		Path:       git.String(path.Base(p)),
-		Visibility: git.Visibility(git.PrivateVisibility),
+		Visibility: git.Visibility(s.visibility),
 	}
 
 	if parentId != -1 {
 		groupOptions.ParentID = git.Int(parentId)
 	}
-	group, _, err = s.c.Groups.CreateGroup(groupOptions)
+
+	if dryRun {
+		changes = append(changes, Change{
+			Reason:  "GroupCreated",
+			Message: fmt.Sprintf("Created group %s (%s visibility)", p, s.visibility),
+		})
+		return -1, nil, changes
+	}
+
+	group, _, err = s.git().Groups.CreateGroup(groupOptions, git.WithContext(ctx))
 
 	if err != nil {
-		return -1, errors.New(fmt.Sprintf("Could not create group: %s", err.Error()))
+		s.forgetAlong(p)
+		return -1, wrap("Could not create group", err), changes
 	}
+	s.groups.set(p, group.ID)
 
-	return group.ID, nil
+	changes = append(changes, Change{
+		Reason:  "GroupCreated",
+		Message: fmt.Sprintf("Created group %s (id %d, %s visibility): %s", group.FullPath, group.ID, s.visibility, group.WebURL),
+	})
+
+	return group.ID, nil, changes
+}
+
+// Project is what is reported of a reconciled GitLab project.
+type Project struct {
+	ID     int
+	WebURL string
 }
 
-func (s *Client) ReconcileProject(p appv1.ProjectPath) (error, bool) {
-	project, err := s.FindProjects(p)
+// ReconcileProject creates the project at p, along with its missing parent
+// groups, or updates its name and description. With dryRun, the changes are
+// computed and returned but not written.
+func (s *Client) ReconcileProject(ctx context.Context, p appv1.ProjectPath, dryRun bool) (*Project, error, []Change) {
+	project, err := s.FindProjects(ctx, p)
 	if err != nil {
-		return err, false
+		return nil, err, nil
+	}
+
+	if p.Name == "" {
+		p.Name = path.Base(p.Path)
+	}
+
+	var changes []Change
+
+	if project != nil && pendingDeletion(project) {
+		if !dryRun {
+			if err = s.restoreProject(ctx, project); err != nil {
+				s.forgetAlong(p.Path)
+				return nil, err, nil
+			}
+		}
+		changes = append(changes, Change{
+			Reason:  "ProjectRestored",
+			Message: fmt.Sprintf("Restored project %s (id %d) pending deletion", project.WebURL, project.ID),
+		})
 	}
 
 	if project != nil {
-		if project.Name == p.Name && project.Description == p.Description {
-			return nil, false
+		var diff []string
+		if project.Name != p.Name {
+			diff = append(diff, fmt.Sprintf("name %q -> %q", project.Name, p.Name))
+		}
+		if project.Description != p.Description {
+			diff = append(diff, fmt.Sprintf("description %q -> %q", project.Description, p.Description))
+		}
+		if len(diff) == 0 {
+			return &Project{ID: project.ID, WebURL: project.WebURL}, nil, changes
+		}
+
+		changes = append(changes, Change{
+			Reason:  "ProjectUpdated",
+			Message: fmt.Sprintf("Updated project %s (id %d): %s", project.WebURL, project.ID, strings.Join(diff, ", ")),
+		})
+
+		if dryRun {
+			return &Project{ID: project.ID, WebURL: project.WebURL}, nil, changes
 		}
 
-		if _, _, err = s.c.Projects.EditProject(project.ID, &git.EditProjectOptions{
+		if _, _, err = s.git().Projects.EditProject(project.ID, &git.EditProjectOptions{
 			Name:        git.String(p.Name),
 			Description: git.String(p.Description),
-		}); err != nil {
-			return errors.New(fmt.Sprintf("Could not edit project: %s", err.Error())), false
+		}, git.WithContext(ctx)); err != nil {
+			s.forgetAlong(p.Path)
+			return nil, wrap("Could not edit project", err), changes
 		}
 	} else {
-		parentId, err := s.ensurePathExists(path.Dir(p.Path))
+		parentId, err, pathChanges := s.ensurePathExists(ctx, path.Dir(p.Path), dryRun)
+		changes = append(changes, pathChanges...)
 		if err != nil {
-			return err, false
+			return nil, err, changes
 		}
 
-		if _, _, err = s.c.Projects.CreateProject(&git.CreateProjectOptions{
+		if dryRun {
+			changes = append(changes, Change{
+				Reason:  "ProjectCreated",
+				Message: fmt.Sprintf("Created project %s (%s visibility)", p.Path, s.visibility),
+			})
+			return &Project{}, nil, changes
+		}
+
+		if project, _, err = s.git().Projects.CreateProject(&git.CreateProjectOptions{
 			Name:        git.String(p.Name),
 			Description: git.String(p.Description),
-			Visibility:  git.Visibility(git.PrivateVisibility),
+			Visibility:  git.Visibility(s.visibility),
 			Path:        git.String(path.Base(p.Path)),
 			NamespaceID: git.Int(parentId),
-		}); err != nil {
-			return errors.New(fmt.Sprintf("Could not create project: %s", err.Error())), false
+		}, git.WithContext(ctx)); err != nil {
+			s.forgetAlong(p.Path)
+			return nil, wrap("Could not create project", err), changes
 		}
+		s.projects.set(p.Path, project.ID)
+
+		changes = append(changes, Change{
+			Reason:  "ProjectCreated",
+			Message: fmt.Sprintf("Created project %s (id %d, %s visibility): %s", project.PathWithNamespace, project.ID, s.visibility, project.WebURL),
+		})
 	}
 
-	return nil, true
+	return &Project{ID: project.ID, WebURL: project.WebURL}, nil, changes
 }
 
-func (s *Client) DeleteProject(p appv1.ProjectPath) (error, bool) {
-	project, err := s.FindProjects(p)
+// DeleteProject applies policy to the project at p. Projects to be deleted with
+// a grace period are archived and deleted by PurgeProjects once it ended. A
+// project that is already gone, or already pending deletion on GitLab, is not
+// an error. With dryRun, the change is returned but not written.
+func (s *Client) DeleteProject(ctx context.Context, p appv1.ProjectPath, policy appv1.DeletionPolicy, grace time.Duration, dryRun bool) (error, []Change) {
+	if policy == appv1.DeletionRetain {
+		return nil, []Change{{
+			Reason:  "ProjectRetained",
+			Message: fmt.Sprintf("Left project %s in place", p.Path),
+		}}
+	}
+
+	project, err := s.FindProjects(ctx, p)
 	if err != nil {
-		return err, false
+		return err, nil
 	}
 
-	if project == nil {
-		return errors.New(fmt.Sprintf("Could not find project.")), false
+	if project == nil || project.MarkedForDeletionAt != nil {
+		return nil, nil
 	}
 
-	if p.ArchiveOnDelete {
-		_, _, err = s.c.Projects.ArchiveProject(project.ID)
-	} else {
-		_, err = s.c.Projects.DeleteProject(project.ID)
+	switch {
+	case policy == appv1.DeletionArchive:
+		if project.Archived {
+			return nil, nil
+		}
+		if !dryRun {
+			_, _, err = s.git().Projects.ArchiveProject(project.ID, git.WithContext(ctx))
+			if err = wrap("Could not archive project", err); backend.IsNotFound(err) {
+				s.Forget(p.Path)
+				return nil, nil
+			} else if err != nil {
+				s.forgetAlong(p.Path)
+				return err, nil
+			}
+		}
+		return nil, []Change{{
+			Reason:  "ProjectArchived",
+			Message: fmt.Sprintf("Archived project %s (id %d)", project.WebURL, project.ID),
+		}}
+	case grace > 0:
+		if pendingDeletion(project) {
+			return nil, nil
+		}
+		deadline := time.Now().Add(grace)
+		if !dryRun {
+			if err = s.scheduleDeletion(ctx, project, deadline); backend.IsNotFound(err) {
+				s.Forget(p.Path)
+				return nil, nil
+			} else if err != nil {
+				s.forgetAlong(p.Path)
+				return err, nil
+			}
+		}
+		return nil, []Change{{
+			Reason:  "ProjectDeletionScheduled",
+			Message: fmt.Sprintf("Archived project %s (id %d) until its deletion after %s", project.WebURL, project.ID, deadline.UTC().Format(time.RFC3339)),
+		}}
 	}
 
-	return err, true
+	if !dryRun {
+		_, err = s.git().Projects.DeleteProject(project.ID, git.WithContext(ctx))
+		if err = wrap("Could not delete project", err); err != nil && !backend.IsNotFound(err) {
+			s.forgetAlong(p.Path)
+			return err, nil
+		}
+		s.Forget(p.Path)
+		if err != nil {
+			return nil, nil
+		}
+	}
+	return nil, []Change{{
+		Reason:  "ProjectDeleted",
+		Message: fmt.Sprintf("Deleted project %s (id %d)", project.WebURL, project.ID),
+	}}
 }
@@ -0,0 +1,95 @@
+package gitlab
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPathCacheTTL is a sensible time to remember the id of a group or
+// project for.
+const DefaultPathCacheTTL = 10 * time.Minute
+
+// pathCache maps the full paths of groups, or of projects, to their ids. It
+// saves looking up paths that were already resolved, an entry is forgotten
+// once its ttl elapsed or when a write on it failed.
+type pathCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]pathEntry
+}
+
+type pathEntry struct {
+	id      int
+	expires time.Time
+}
+
+func newPathCache(ttl time.Duration) *pathCache {
+	return &pathCache{ttl: ttl, entries: map[string]pathEntry{}}
+}
+
+func (c *pathCache) get(p string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[p]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, p)
+		return 0, false
+	}
+
+	return entry.id, true
+}
+
+func (c *pathCache) set(p string, id int) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[p] = pathEntry{id: id, expires: time.Now().Add(c.ttl)}
+}
+
+// forget drops p and every path below it, as moving or deleting a group also
+// moves or deletes what it holds.
+func (c *pathCache) forget(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.entries {
+		if key == p || strings.HasPrefix(key, p+"/") {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// drop forgets p alone.
+func (c *pathCache) drop(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, p)
+}
+
+// Forget drops what is remembered of the group or project at p and of what
+// it holds, for the next lookups to ask GitLab again.
+func (s *Client) Forget(p string) {
+	s.groups.forget(p)
+	s.projects.forget(p)
+}
+
+// forgetAlong drops what is remembered at p and the groups it is in, after a
+// write on them failed, since any of them may have been moved or deleted.
+func (s *Client) forgetAlong(p string) {
+	s.Forget(p)
+	for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		s.groups.drop(dir)
+	}
+}
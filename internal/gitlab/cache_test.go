@@ -0,0 +1,100 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/gitlab/gitlabtest"
+)
+
+var _ = Describe("pathCache", func() {
+	It("forgets entries once their ttl elapsed", func() {
+		cache := newPathCache(time.Hour)
+		cache.set("infra/wellerman", 42)
+
+		id, ok := cache.get("infra/wellerman")
+		Expect(ok).To(BeTrue())
+		Expect(id).To(Equal(42))
+
+		cache.entries["infra/wellerman"] = pathEntry{id: 42, expires: time.Now().Add(-time.Second)}
+		_, ok = cache.get("infra/wellerman")
+		Expect(ok).To(BeFalse())
+		Expect(cache.entries).To(BeEmpty())
+	})
+
+	It("remembers nothing without a ttl", func() {
+		cache := newPathCache(0)
+		cache.set("infra/wellerman", 42)
+
+		_, ok := cache.get("infra/wellerman")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("forgets a path along with what it holds", func() {
+		cache := newPathCache(time.Hour)
+		for i, p := range []string{"infra", "infra/tools", "infra/tools/wellerman", "infrastructure"} {
+			cache.set(p, i)
+		}
+
+		cache.forget("infra/tools")
+		Expect(cache.entries).To(HaveLen(2))
+		Expect(cache.entries).To(HaveKey("infra"))
+		Expect(cache.entries).To(HaveKey("infrastructure"))
+	})
+
+	It("drops a path alone", func() {
+		cache := newPathCache(time.Hour)
+		cache.set("infra", 1)
+		cache.set("infra/wellerman", 2)
+
+		cache.drop("infra")
+		Expect(cache.entries).To(HaveLen(1))
+		Expect(cache.entries).To(HaveKey("infra/wellerman"))
+	})
+})
+
+var _ = Describe("Client path cache", func() {
+	var (
+		ctx    context.Context
+		server *gitlabtest.Server
+		client *Client
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = gitlabtest.NewServer()
+		DeferCleanup(server.Close)
+
+		var err error
+		client, err = NewInstance(server.URL, "token", Options{PathCacheTTL: time.Hour})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("reads remembered projects by id", func() {
+		project := server.AddProject("infra/tools/wellerman")
+
+		for i := 0; i < 2; i++ {
+			found, err := client.FindProjects(ctx, appv1.ProjectPath{Path: "infra/tools/wellerman"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found.ID).To(Equal(project.ID))
+		}
+		Expect(server.Requests()).To(Equal([]string{"GET /projects", fmt.Sprintf("GET /projects/%d", project.ID)}))
+	})
+
+	It("forgets the groups along a path a write failed on", func() {
+		client.groups.set("infra", 1)
+		client.groups.set("infra/tools", 2)
+		client.groups.set("infra/other", 3)
+		client.projects.set("infra/tools/wellerman", 4)
+
+		client.forgetAlong("infra/tools/wellerman")
+		Expect(client.groups.entries).To(HaveLen(1))
+		Expect(client.groups.entries).To(HaveKey("infra/other"))
+		Expect(client.projects.entries).To(BeEmpty())
+	})
+})
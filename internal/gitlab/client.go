@@ -1,28 +1,135 @@
 package gitlab
 
 import (
-	"fmt"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	git "github.com/xanzy/go-gitlab"
 )
 
+const (
+	errInvalidCA = "could not parse any certificate from the CA bundle"
+)
+
+const apiPrefix = "/api/v4"
+
+// Options configures how an instance is reached and the defaults applied to
+// what is created on it.
+type Options struct {
+	// CA is a PEM bundle used to verify the certificate of the instance.
+	CA                 []byte
+	InsecureSkipVerify bool
+
+	// Visibility of the projects and groups created. Defaults to private.
+	Visibility string
+
+	RateLimit RateLimitOptions
+
+	// PathCacheTTL is how long the ids of groups and projects are remembered
+	// for, 0 to look them up every time.
+	PathCacheTTL time.Duration
+
+	// Schedule records the projects scheduled for deletion, the only ones
+	// purged. Defaults to one held in memory, lost on restart.
+	Schedule Schedule
+}
+
 type Client struct {
-	gitlabURL string
-	token     string
-	c         *git.Client
+	gitlabURL     string
+	clientOptions []git.ClientOptionFunc
+	visibility    git.VisibilityValue
+	c             atomic.Pointer[git.Client]
+	transport     *http.Transport
+	limiter       *limiter
+	release       sync.Once
+
+	groups   *pathCache
+	projects *pathCache
+	schedule Schedule
 }
 
-func NewInstance(gitlabURL, token string) (*Client, error) {
-	client, err := git.NewClient(token, git.WithBaseURL(fmt.Sprintf(`%s/api/v4`, gitlabURL)))
-	if err != nil {
-		return nil, err
+func NewInstance(gitlabURL, token string, options Options) (*Client, error) {
+	limiter := limiterFor(gitlabURL, options.RateLimit)
+	clientOptions := []git.ClientOptionFunc{
+		git.WithBaseURL(gitlabURL + apiPrefix),
+		git.WithCustomLimiter(limiter),
+		git.WithCustomBackoff(backoff),
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(options.CA) > 0 || options.InsecureSkipVerify {
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: options.InsecureSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		}
+		if len(options.CA) > 0 {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(options.CA) {
+				limiter.release()
+				return nil, errors.New(errInvalidCA)
+			}
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+	clientOptions = append(clientOptions, git.WithHTTPClient(&http.Client{
+		Transport: &limitedTransport{base: transport, limiter: limiter},
+	}))
+
+	visibility := git.VisibilityValue(options.Visibility)
+	if visibility == "" {
+		visibility = git.PrivateVisibility
 	}
 
 	s := &Client{
-		gitlabURL: gitlabURL,
-		token:     token,
-		c:         client,
+		gitlabURL:     gitlabURL,
+		clientOptions: clientOptions,
+		visibility:    visibility,
+		transport:     transport,
+		limiter:       limiter,
+		groups:        newPathCache(options.PathCacheTTL),
+		projects:      newPathCache(options.PathCacheTTL),
+		schedule:      options.Schedule,
+	}
+	if s.schedule == nil {
+		s.schedule = newMemorySchedule()
+	}
+
+	if err := s.SetToken(token); err != nil {
+		limiter.release()
+		return nil, err
 	}
 
 	return s, nil
 }
+
+// SetToken replaces the token used to authenticate with. Requests already
+// sent complete with the previous one.
+func (s *Client) SetToken(token string) error {
+	client, err := git.NewClient(token, s.clientOptions...)
+	if err != nil {
+		return err
+	}
+
+	s.c.Store(client)
+
+	return nil
+}
+
+// Close closes the idle connections to the instance, and releases the rate
+// limiter it shares with the other clients of the instance. Requests can still
+// be sent afterwards, over new connections, the limiter no longer being
+// shared.
+func (s *Client) Close() {
+	s.transport.CloseIdleConnections()
+	s.release.Do(s.limiter.release)
+}
+
+func (s *Client) git() *git.Client {
+	return s.c.Load()
+}
@@ -0,0 +1,59 @@
+package gitlab
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	git "github.com/xanzy/go-gitlab"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+)
+
+// wrap classifies err by the HTTP status GitLab answered with, and prefixes
+// its message with op.
+func wrap(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	e := &backend.Error{Kind: backend.Unknown, Op: op, Err: err}
+
+	var response *git.ErrorResponse
+	var netErr net.Error
+	switch {
+	case errors.As(err, &response) && response.Response != nil:
+		e.Kind = statusKind(response.Response.StatusCode)
+		if e.Kind == backend.RateLimited {
+			if seconds, err := strconv.Atoi(response.Response.Header.Get("Retry-After")); err == nil {
+				e.RetryAfter = time.Duration(seconds) * time.Second
+			}
+		}
+	case errors.As(err, &netErr):
+		e.Kind = backend.Transient
+	}
+
+	return e
+}
+
+// statusKind classifies an HTTP status GitLab answered with. Statuses that
+// are not errors are Unknown.
+func statusKind(status int) backend.Kind {
+	switch {
+	case status == http.StatusNotFound:
+		return backend.NotFound
+	case status == http.StatusConflict:
+		return backend.Conflict
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return backend.Unauthorized
+	case status == http.StatusTooManyRequests:
+		return backend.RateLimited
+	case status >= http.StatusInternalServerError:
+		return backend.Transient
+	case status >= http.StatusBadRequest:
+		return backend.Invalid
+	}
+	return backend.Unknown
+}
@@ -0,0 +1,69 @@
+package gitlab
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	git "github.com/xanzy/go-gitlab"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+)
+
+var _ = DescribeTable("statusKind",
+	func(status int, kind backend.Kind) {
+		Expect(statusKind(status)).To(Equal(kind))
+	},
+	Entry("200", http.StatusOK, backend.Unknown),
+	Entry("400", http.StatusBadRequest, backend.Invalid),
+	Entry("401", http.StatusUnauthorized, backend.Unauthorized),
+	Entry("403", http.StatusForbidden, backend.Unauthorized),
+	Entry("404", http.StatusNotFound, backend.NotFound),
+	Entry("409", http.StatusConflict, backend.Conflict),
+	Entry("422", http.StatusUnprocessableEntity, backend.Invalid),
+	Entry("429", http.StatusTooManyRequests, backend.RateLimited),
+	Entry("500", http.StatusInternalServerError, backend.Transient),
+	Entry("503", http.StatusServiceUnavailable, backend.Transient),
+)
+
+var _ = Describe("wrap", func() {
+	response := func(status int, header http.Header) error {
+		return &git.ErrorResponse{Response: &http.Response{
+			StatusCode: status,
+			Header:     header,
+			Request:    &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/api/v4/projects/42"}},
+		}}
+	}
+
+	It("leaves nil alone", func() {
+		Expect(wrap("Could not get project", nil)).To(BeNil())
+	})
+
+	It("classifies GitLab answers by their status", func() {
+		err := wrap("Could not get project", response(http.StatusNotFound, http.Header{}))
+		Expect(backend.KindOf(err)).To(Equal(backend.NotFound))
+		Expect(err.Error()).To(HavePrefix("Could not get project: "))
+	})
+
+	It("keeps the delay asked by GitLab when rate limited", func() {
+		err := wrap("Could not get project", response(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"30"}}))
+		Expect(backend.KindOf(err)).To(Equal(backend.RateLimited))
+		Expect(backend.RetryAfterOf(err)).To(Equal(30 * time.Second))
+
+		err = wrap("Could not get project", response(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"soon"}}))
+		Expect(backend.RetryAfterOf(err)).To(BeZero())
+	})
+
+	It("classifies network failures as transient", func() {
+		err := wrap("Could not get project", &net.OpError{Op: "dial", Err: errors.New("connection refused")})
+		Expect(backend.KindOf(err)).To(Equal(backend.Transient))
+	})
+
+	It("leaves other errors unclassified", func() {
+		Expect(backend.KindOf(wrap("Could not get project", errors.New("boom")))).To(Equal(backend.Unknown))
+	})
+})
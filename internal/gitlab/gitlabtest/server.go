@@ -0,0 +1,349 @@
+// Package gitlabtest serves an in-memory GitLab, holding groups and projects,
+// for the clients of internal/gitlab to be tested against.
+package gitlabtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Project is a project of the fake instance.
+type Project struct {
+	ID                  int      `json:"id"`
+	Name                string   `json:"name"`
+	Path                string   `json:"path"`
+	PathWithNamespace   string   `json:"path_with_namespace"`
+	Description         string   `json:"description"`
+	WebURL              string   `json:"web_url"`
+	Archived            bool     `json:"archived"`
+	Topics              []string `json:"topics"`
+	MarkedForDeletionAt *string  `json:"marked_for_deletion_at,omitempty"`
+}
+
+// Group is a group of the fake instance.
+type Group struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	FullPath string `json:"full_path"`
+	ParentID int    `json:"parent_id,omitempty"`
+	WebURL   string `json:"web_url"`
+}
+
+// Server is a fake GitLab instance, listening until closed.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	nextID   int
+	projects map[int]*Project
+	groups   map[int]*Group
+	requests []string
+}
+
+// NewServer starts a fake GitLab instance without any group nor project.
+func NewServer() *Server {
+	s := &Server{
+		nextID:   1,
+		projects: map[int]*Project{},
+		groups:   map[int]*Group{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// AddGroup creates the group at fullPath, along with its missing parents, and
+// returns it.
+func (s *Server) AddGroup(fullPath string) *Group {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addGroup(fullPath)
+}
+
+func (s *Server) addGroup(fullPath string) *Group {
+	if group := s.groupAt(fullPath); group != nil {
+		return group
+	}
+
+	group := &Group{
+		ID:       s.id(),
+		Name:     path.Base(fullPath),
+		Path:     path.Base(fullPath),
+		FullPath: fullPath,
+		WebURL:   s.URL + "/groups/" + fullPath,
+	}
+	if parent := path.Dir(fullPath); parent != "." {
+		group.ParentID = s.addGroup(parent).ID
+	}
+	s.groups[group.ID] = group
+
+	return group
+}
+
+// AddProject creates the project at fullPath, along with its missing groups,
+// and returns it. Changes to the project returned are seen by the clients.
+func (s *Server) AddProject(fullPath string) *Project {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addGroup(path.Dir(fullPath))
+
+	return s.addProject(fullPath)
+}
+
+func (s *Server) addProject(fullPath string) *Project {
+	project := &Project{
+		ID:                s.id(),
+		Name:              path.Base(fullPath),
+		Path:              path.Base(fullPath),
+		PathWithNamespace: fullPath,
+		WebURL:            s.URL + "/" + fullPath,
+		Topics:            []string{},
+	}
+	s.projects[project.ID] = project
+
+	return project
+}
+
+// Project returns the project at fullPath, nil when there is none.
+func (s *Server) Project(fullPath string) *Project {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, project := range s.projects {
+		if project.PathWithNamespace == fullPath {
+			return project
+		}
+	}
+	return nil
+}
+
+// RemoveProject removes the project at fullPath, as if it was deleted outside
+// of the clients.
+func (s *Server) RemoveProject(fullPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, project := range s.projects {
+		if project.PathWithNamespace == fullPath {
+			delete(s.projects, id)
+		}
+	}
+}
+
+// Requests returns the requests received so far, as "METHOD /path" without
+// the /api/v4 prefix.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// Writes returns the requests received so far that were not GETs.
+func (s *Server) Writes() []string {
+	var writes []string
+	for _, request := range s.Requests() {
+		if !strings.HasPrefix(request, http.MethodGet+" ") {
+			writes = append(writes, request)
+		}
+	}
+	return writes
+}
+
+func (s *Server) id() int {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+func (s *Server) groupAt(fullPath string) *Group {
+	for _, group := range s.groups {
+		if group.FullPath == fullPath {
+			return group
+		}
+	}
+	return nil
+}
+
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := strings.TrimPrefix(req.URL.Path, "/api/v4")
+	s.requests = append(s.requests, req.Method+" "+p)
+
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	switch {
+	case segments[0] == "projects":
+		s.serveProjects(w, req, segments[1:])
+	case segments[0] == "groups":
+		s.serveGroups(w, req, segments[1:])
+	default:
+		reply(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+	}
+}
+
+func (s *Server) serveProjects(w http.ResponseWriter, req *http.Request, segments []string) {
+	if len(segments) == 0 {
+		switch req.Method {
+		case http.MethodGet:
+			search, topic := req.URL.Query().Get("search"), req.URL.Query().Get("topic")
+			projects := []*Project{}
+			for _, project := range s.sortedProjects() {
+				if search != "" && !strings.Contains(project.Path, search) {
+					continue
+				}
+				if topic != "" && !contains(project.Topics, topic) {
+					continue
+				}
+				projects = append(projects, project)
+			}
+			reply(w, http.StatusOK, projects)
+		case http.MethodPost:
+			var options struct {
+				Name        string `json:"name"`
+				Path        string `json:"path"`
+				Description string `json:"description"`
+				NamespaceID int    `json:"namespace_id"`
+			}
+			if !decode(w, req, &options) {
+				return
+			}
+			namespace, ok := s.groups[options.NamespaceID]
+			if !ok {
+				reply(w, http.StatusBadRequest, map[string]string{"message": "namespace is invalid"})
+				return
+			}
+			project := s.addProject(namespace.FullPath + "/" + options.Path)
+			project.Name = options.Name
+			project.Description = options.Description
+			reply(w, http.StatusCreated, project)
+		default:
+			reply(w, http.StatusMethodNotAllowed, nil)
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(segments[0])
+	project, ok := s.projects[id]
+	if err != nil || !ok {
+		reply(w, http.StatusNotFound, map[string]string{"message": "404 Project Not Found"})
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && req.Method == http.MethodGet:
+		reply(w, http.StatusOK, project)
+	case len(segments) == 1 && req.Method == http.MethodPut:
+		var options struct {
+			Name        *string   `json:"name"`
+			Description *string   `json:"description"`
+			Topics      *[]string `json:"topics"`
+		}
+		if !decode(w, req, &options) {
+			return
+		}
+		if options.Name != nil {
+			project.Name = *options.Name
+		}
+		if options.Description != nil {
+			project.Description = *options.Description
+		}
+		if options.Topics != nil {
+			project.Topics = *options.Topics
+		}
+		reply(w, http.StatusOK, project)
+	case len(segments) == 1 && req.Method == http.MethodDelete:
+		delete(s.projects, id)
+		reply(w, http.StatusAccepted, map[string]string{"message": "202 Accepted"})
+	case len(segments) == 2 && segments[1] == "archive" && req.Method == http.MethodPost:
+		project.Archived = true
+		reply(w, http.StatusCreated, project)
+	case len(segments) == 2 && segments[1] == "unarchive" && req.Method == http.MethodPost:
+		project.Archived = false
+		reply(w, http.StatusCreated, project)
+	default:
+		reply(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+	}
+}
+
+func (s *Server) serveGroups(w http.ResponseWriter, req *http.Request, segments []string) {
+	if len(segments) != 0 {
+		reply(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		search := req.URL.Query().Get("search")
+		groups := []*Group{}
+		for id := 1; id < s.nextID; id++ {
+			if group, ok := s.groups[id]; ok && strings.Contains(group.FullPath, search) {
+				groups = append(groups, group)
+			}
+		}
+		reply(w, http.StatusOK, groups)
+	case http.MethodPost:
+		var options struct {
+			Path     string `json:"path"`
+			ParentID int    `json:"parent_id"`
+		}
+		if !decode(w, req, &options) {
+			return
+		}
+		fullPath := options.Path
+		if parent, ok := s.groups[options.ParentID]; ok {
+			fullPath = parent.FullPath + "/" + options.Path
+		}
+		if s.groupAt(fullPath) != nil {
+			reply(w, http.StatusBadRequest, map[string]string{"message": "Failed to save group"})
+			return
+		}
+		reply(w, http.StatusCreated, s.addGroup(fullPath))
+	default:
+		reply(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func (s *Server) sortedProjects() []*Project {
+	var projects []*Project
+	for id := 1; id < s.nextID; id++ {
+		if project, ok := s.projects[id]; ok {
+			projects = append(projects, project)
+		}
+	}
+	return projects
+}
+
+func decode(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		reply(w, http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("invalid body: %s", err)})
+		return false
+	}
+	return true
+}
+
+func reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// HookTokenHeader holds the secret token GitLab sends along its hooks.
+const HookTokenHeader = "X-Gitlab-Token"
+
+// HookEvent is what a GitLab system hook tells of the groups and projects it
+// is about.
+type HookEvent struct {
+	// Name is the event_name of the hook, such as project_rename.
+	Name string
+	// Paths are the full paths of the groups and projects the event is about,
+	// before and after it when they changed.
+	Paths []string
+	// ProjectID is the id of the project the event is about, 0 for events
+	// about groups.
+	ProjectID int
+}
+
+// systemHook holds the fields of the system hooks about projects, groups and
+// their members that wellerman cares about.
+type systemHook struct {
+	EventName string `json:"event_name"`
+
+	ProjectID                int    `json:"project_id"`
+	PathWithNamespace        string `json:"path_with_namespace"`
+	OldPathWithNamespace     string `json:"old_path_with_namespace"`
+	ProjectPathWithNamespace string `json:"project_path_with_namespace"`
+
+	FullPath    string `json:"full_path"`
+	OldFullPath string `json:"old_full_path"`
+}
+
+// ParseHook decodes the body of a system hook. Events that are not about a
+// group or a project come back without any path nor project id.
+func ParseHook(body []byte) (*HookEvent, error) {
+	hook := &systemHook{}
+	if err := json.Unmarshal(body, hook); err != nil {
+		return nil, err
+	}
+
+	event := &HookEvent{Name: hook.EventName}
+
+	switch {
+	case strings.HasPrefix(hook.EventName, "project_"):
+		event.ProjectID = hook.ProjectID
+		event.Paths = nonEmpty(hook.PathWithNamespace, hook.OldPathWithNamespace)
+	case strings.HasSuffix(hook.EventName, "_team"):
+		// user_add_to_team and the like are about the members of a project.
+		event.ProjectID = hook.ProjectID
+		event.Paths = nonEmpty(hook.ProjectPathWithNamespace)
+	case strings.HasPrefix(hook.EventName, "group_"):
+		event.Paths = nonEmpty(hook.FullPath, hook.OldFullPath)
+	}
+
+	return event, nil
+}
+
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
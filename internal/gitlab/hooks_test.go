@@ -0,0 +1,36 @@
+package gitlab
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("ParseHook",
+	func(body string, expected *HookEvent) {
+		event, err := ParseHook([]byte(body))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(event).To(Equal(expected))
+	},
+	Entry("a project creation",
+		`{"event_name": "project_create", "project_id": 42, "path_with_namespace": "infra/wellerman"}`,
+		&HookEvent{Name: "project_create", ProjectID: 42, Paths: []string{"infra/wellerman"}}),
+	Entry("a project transfer",
+		`{"event_name": "project_transfer", "project_id": 42, "path_with_namespace": "platform/wellerman", "old_path_with_namespace": "infra/wellerman"}`,
+		&HookEvent{Name: "project_transfer", ProjectID: 42, Paths: []string{"platform/wellerman", "infra/wellerman"}}),
+	Entry("a project member added",
+		`{"event_name": "user_add_to_team", "project_id": 42, "project_path_with_namespace": "infra/wellerman"}`,
+		&HookEvent{Name: "user_add_to_team", ProjectID: 42, Paths: []string{"infra/wellerman"}}),
+	Entry("a group rename",
+		`{"event_name": "group_rename", "full_path": "platform", "old_full_path": "infra"}`,
+		&HookEvent{Name: "group_rename", Paths: []string{"platform", "infra"}}),
+	Entry("an event about neither",
+		`{"event_name": "user_create", "project_id": 42}`,
+		&HookEvent{Name: "user_create"}),
+)
+
+var _ = Describe("ParseHook", func() {
+	It("refuses malformed bodies", func() {
+		_, err := ParseHook([]byte(`{"event_name": `))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,67 @@
+package gitlab
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+)
+
+var (
+	requestWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wellerman_gitlab_request_wait_seconds",
+		Help:    "Time GitLab requests spent queued by the rate limiter, by instance.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"instance"})
+
+	throttled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wellerman_gitlab_throttled_requests_total",
+		Help: "Number of GitLab requests held back, by instance and by whether the token bucket or GitLab asked to wait.",
+	}, []string{"instance", "reason"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wellerman_gitlab_rate_limited_responses_total",
+		Help: "Number of 429 Too Many Requests answered by GitLab, by instance.",
+	}, []string{"instance"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(requestWaitSeconds, throttled, rateLimited)
+}
+
+// operation names the API endpoint of req for the backend metrics, such as
+// "POST /projects/:id/archive". GitLab endpoints alternate collections and
+// ids, "/groups/:id/members/:user_id", so every second segment is replaced by
+// ":id" whatever it holds, an id or a path, for the label to only take a few
+// values. Numbers and encoded paths are replaced wherever they are.
+func operation(req *http.Request) string {
+	p := req.URL.EscapedPath()
+	if i := strings.Index(p, apiPrefix); i >= 0 {
+		p = p[i+len(apiPrefix):]
+	}
+
+	segments := strings.Split(strings.TrimSuffix(p, "/"), "/")
+	for i, segment := range segments {
+		if _, err := strconv.Atoi(segment); err == nil || (i > 0 && i%2 == 0) || strings.Contains(strings.ToUpper(segment), "%2F") {
+			segments[i] = ":id"
+		}
+	}
+
+	return req.Method + " " + strings.Join(segments, "/")
+}
+
+// responseError returns the error a request ended with, the status of resp
+// classified when it is not a success.
+func responseError(resp *http.Response, err error) error {
+	if err != nil {
+		return backend.Wrap(backend.Transient, "", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return backend.New(statusKind(resp.StatusCode), resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package gitlab
+
+import (
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("operation",
+	func(method, path, op string) {
+		u, err := url.Parse("https://gitlab.example.com" + path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(operation(&http.Request{Method: method, URL: u})).To(Equal(op))
+	},
+	Entry("a collection", http.MethodGet, "/api/v4/projects", "GET /projects"),
+	Entry("a numeric id", http.MethodPost, "/api/v4/projects/42/archive", "POST /projects/:id/archive"),
+	Entry("an encoded path", http.MethodGet, "/api/v4/projects/infra%2Fapp", "GET /projects/:id"),
+	Entry("a group path", http.MethodGet, "/api/v4/groups/infra", "GET /groups/:id"),
+	Entry("a member", http.MethodDelete, "/api/v4/groups/1/members/2", "DELETE /groups/:id/members/:id"),
+	Entry("a username", http.MethodGet, "/api/v4/users/jdoe/projects", "GET /users/:id/projects"),
+	Entry("a trailing slash", http.MethodGet, "/api/v4/groups/infra/", "GET /groups/:id"),
+	Entry("a GitLab under a path", http.MethodGet, "/gitlab/api/v4/groups/infra", "GET /groups/:id"),
+)
@@ -0,0 +1,200 @@
+package gitlab
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+	"github.com/vbouchaud/wellerman/internal/tracing"
+)
+
+const (
+	headerRetryAfter         = "Retry-After"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+)
+
+// RateLimitOptions bounds the requests sent to an instance. Every client of
+// the same instance URL shares one token bucket, GitLab limiting requests by
+// user and address rather than by GitlabInstance. The bucket takes the options
+// of the last client created, the operator giving them all the same.
+type RateLimitOptions struct {
+	// RequestsPerSecond is the rate the bucket refills at, 0 for no limit.
+	RequestsPerSecond float64
+	// Burst is the size of the bucket. Defaults to 1 when limited.
+	Burst int
+}
+
+// limiter is a token bucket that is also held back as long as GitLab asks to
+// wait, through Retry-After or an exhausted RateLimit-Remaining.
+type limiter struct {
+	instance string
+	bucket   *rate.Limiter
+	// refs counts the clients using the limiter, guarded by limitersMu.
+	refs int
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = map[string]*limiter{}
+)
+
+// limiterFor returns the limiter shared by the clients of instance, updated
+// to options. It is kept until every client released it.
+func limiterFor(instance string, options RateLimitOptions) *limiter {
+	limit, burst := rate.Inf, 0
+	if options.RequestsPerSecond > 0 {
+		limit, burst = rate.Limit(options.RequestsPerSecond), options.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+	}
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	l, ok := limiters[instance]
+	if !ok {
+		l = &limiter{instance: instance, bucket: rate.NewLimiter(limit, burst)}
+		limiters[instance] = l
+	} else {
+		l.bucket.SetLimit(limit)
+		l.bucket.SetBurst(burst)
+	}
+	l.refs++
+
+	return l
+}
+
+// release forgets the limiter once the last client using it released it.
+func (l *limiter) release() {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	l.refs--
+	if l.refs <= 0 && limiters[l.instance] == l {
+		delete(limiters, l.instance)
+	}
+}
+
+// Wait blocks until a request can be sent. It implements git.RateLimiter.
+func (l *limiter) Wait(ctx context.Context) error {
+	start := time.Now()
+	defer func() {
+		requestWaitSeconds.WithLabelValues(l.instance).Observe(time.Since(start).Seconds())
+	}()
+
+	l.mu.Lock()
+	pause := time.Until(l.until)
+	l.mu.Unlock()
+
+	if pause > 0 {
+		throttled.WithLabelValues(l.instance, "server").Inc()
+		trace.SpanFromContext(ctx).AddEvent("GitLab asked to wait", trace.WithAttributes(attribute.String("wait", pause.String())))
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if !l.bucket.Allow() {
+		throttled.WithLabelValues(l.instance, "bucket").Inc()
+		trace.SpanFromContext(ctx).AddEvent("Waiting for the rate limiter")
+		return l.bucket.Wait(ctx)
+	}
+
+	return nil
+}
+
+// observe holds the following requests back when resp asks to wait.
+func (l *limiter) observe(resp *http.Response) {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		rateLimited.WithLabelValues(l.instance).Inc()
+	}
+
+	wait := retryAfter(resp)
+	if wait <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(wait); until.After(l.until) {
+		l.until = until
+	}
+}
+
+// retryAfter returns how long resp asks to wait before the next request.
+func retryAfter(resp *http.Response) time.Duration {
+	if v := resp.Header.Get(headerRetryAfter); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(v); err == nil {
+			return time.Until(date)
+		}
+	}
+
+	if resp.Header.Get(headerRateLimitRemaining) == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get(headerRateLimitReset), 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0))
+		}
+	}
+
+	return 0
+}
+
+// backoff waits as long as asked by a rate limited response before retrying
+// it, and as go-retryablehttp does otherwise.
+func backoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if wait := retryAfter(resp); wait > 0 {
+			return wait
+		}
+	}
+	return retryablehttp.DefaultBackoff(min, max, attemptNum, resp)
+}
+
+// limitedTransport reports every response to a limiter, and to the backend
+// metrics. Each request is traced in a span of its own.
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *limiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := operation(req)
+	ctx, span := tracing.Start(req.Context(), "gitlab "+op,
+		semconv.HTTPMethod(req.Method),
+		attribute.String("gitlab.instance", t.limiter.instance),
+	)
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err == nil {
+		t.limiter.observe(resp)
+		span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+	}
+
+	result := responseError(resp, err)
+	backend.Observe("gitlab", op, start, result)
+	tracing.End(span, result)
+
+	return resp, err
+}
@@ -0,0 +1,97 @@
+package gitlab
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/time/rate"
+)
+
+var _ = Describe("Rate limiting", func() {
+	response := func(status int, header ...string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}}
+		for i := 0; i < len(header); i += 2 {
+			resp.Header.Set(header[i], header[i+1])
+		}
+		return resp
+	}
+
+	It("shares one limiter between the clients of an instance until they all released it", func() {
+		const instance = "https://gitlab.example.org/shared"
+
+		a := limiterFor(instance, RateLimitOptions{RequestsPerSecond: 10})
+		b := limiterFor(instance, RateLimitOptions{RequestsPerSecond: 5, Burst: 3})
+		Expect(b).To(BeIdenticalTo(a))
+		Expect(a.bucket.Limit()).To(Equal(rate.Limit(5)))
+		Expect(a.bucket.Burst()).To(Equal(3))
+		other := limiterFor("https://gitlab.example.org/other", RateLimitOptions{})
+		DeferCleanup(other.release)
+		Expect(other).NotTo(BeIdenticalTo(a))
+
+		a.release()
+		Expect(limiters).To(HaveKey(instance))
+		b.release()
+		Expect(limiters).NotTo(HaveKey(instance))
+
+		c := limiterFor(instance, RateLimitOptions{})
+		DeferCleanup(c.release)
+		Expect(c).NotTo(BeIdenticalTo(a))
+	})
+
+	It("does not limit clients without a rate", func() {
+		l := limiterFor("https://gitlab.example.org/unlimited", RateLimitOptions{})
+		DeferCleanup(l.release)
+
+		Expect(l.bucket.Limit()).To(Equal(rate.Inf))
+	})
+
+	It("holds requests back as long as GitLab asked", func() {
+		l := limiterFor("https://gitlab.example.org/held", RateLimitOptions{})
+		DeferCleanup(l.release)
+
+		l.observe(response(http.StatusTooManyRequests, headerRetryAfter, "60"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		Expect(l.Wait(ctx)).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("releases the limiter of closed clients once", func() {
+		c, err := NewInstance("https://gitlab.example.org/closed", "token", Options{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(limiters).To(HaveKey("https://gitlab.example.org/closed"))
+
+		c.Close()
+		c.Close()
+		Expect(limiters).NotTo(HaveKey("https://gitlab.example.org/closed"))
+	})
+
+	DescribeTable("retryAfter",
+		func(resp *http.Response, min, max time.Duration) {
+			wait := retryAfter(resp)
+			Expect(wait).To(BeNumerically(">=", min))
+			Expect(wait).To(BeNumerically("<=", max))
+		},
+		Entry("without any header", response(http.StatusOK), time.Duration(0), time.Duration(0)),
+		Entry("with Retry-After in seconds", response(http.StatusTooManyRequests, headerRetryAfter, "30"), 30*time.Second, 30*time.Second),
+		Entry("with Retry-After as a date", response(http.StatusTooManyRequests, headerRetryAfter, time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)), 58*time.Second, time.Minute),
+		Entry("with a malformed Retry-After", response(http.StatusTooManyRequests, headerRetryAfter, "soon"), time.Duration(0), time.Duration(0)),
+		Entry("with requests remaining", response(http.StatusOK, headerRateLimitRemaining, "10", headerRateLimitReset, strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)), time.Duration(0), time.Duration(0)),
+		Entry("with no request remaining", response(http.StatusOK, headerRateLimitRemaining, "0", headerRateLimitReset, strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)), 58*time.Second, time.Minute),
+	)
+
+	DescribeTable("backoff",
+		func(resp *http.Response, attempt int, wait time.Duration) {
+			Expect(backoff(time.Second, time.Minute, attempt, resp)).To(Equal(wait))
+		},
+		Entry("without a response", nil, 2, 4*time.Second),
+		Entry("after a server error", response(http.StatusInternalServerError, headerRetryAfter, "30"), 1, 2*time.Second),
+		Entry("after a rate limited response", response(http.StatusTooManyRequests, headerRetryAfter, "30"), 1, 30*time.Second),
+		Entry("after a rate limited response without delay", response(http.StatusTooManyRequests), 3, 8*time.Second),
+		Entry("capped", nil, 10, time.Minute),
+	)
+})
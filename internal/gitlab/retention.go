@@ -0,0 +1,243 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/xanzy/go-gitlab"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+)
+
+const (
+	// pendingDeletionTopic marks the projects archived until their deletion,
+	// for them to be listed.
+	pendingDeletionTopic = "wellerman-pending-deletion"
+	// deleteAfterTopicPrefix prefixes the topic holding the time after which
+	// a project pending deletion is deleted.
+	deleteAfterTopicPrefix = "wellerman-delete-after-"
+	deleteAfterLayout      = "20060102T150405Z"
+)
+
+// Schedule records the projects scheduled for deletion by wellerman. The
+// topics marking them on GitLab can be set by any maintainer of a project, so
+// PurgeProjects only deletes the projects recorded here.
+type Schedule interface {
+	// Add records that the project id is to be deleted after deadline.
+	Add(ctx context.Context, id int, deadline time.Time) error
+	// Remove forgets about the deletion of the project id.
+	Remove(ctx context.Context, id int) error
+	// Deadlines returns the deadlines of the projects recorded, by id.
+	Deadlines(ctx context.Context) (map[int]time.Time, error)
+}
+
+// memorySchedule is the Schedule of the clients given none. It is lost when
+// the process exits, the projects it held being then archived for good.
+type memorySchedule struct {
+	mu        sync.Mutex
+	deadlines map[int]time.Time
+}
+
+func newMemorySchedule() *memorySchedule {
+	return &memorySchedule{deadlines: map[int]time.Time{}}
+}
+
+func (m *memorySchedule) Add(_ context.Context, id int, deadline time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deadlines[id] = deadline
+	return nil
+}
+
+func (m *memorySchedule) Remove(_ context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.deadlines, id)
+	return nil
+}
+
+func (m *memorySchedule) Deadlines(_ context.Context) (map[int]time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deadlines := make(map[int]time.Time, len(m.deadlines))
+	for id, deadline := range m.deadlines {
+		deadlines[id] = deadline
+	}
+	return deadlines, nil
+}
+
+// retentionTopic tells whether topic is one of those set by scheduleDeletion.
+func retentionTopic(topic string) bool {
+	return topic == pendingDeletionTopic || strings.HasPrefix(topic, deleteAfterTopicPrefix)
+}
+
+// deletionDeadline returns the time after which a project pending deletion is
+// to be deleted.
+func deletionDeadline(topics []string) (time.Time, bool) {
+	for _, topic := range topics {
+		if strings.HasPrefix(topic, deleteAfterTopicPrefix) {
+			deadline, err := time.Parse(deleteAfterLayout, strings.ToUpper(strings.TrimPrefix(topic, deleteAfterTopicPrefix)))
+			return deadline, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// pendingDeletion tells whether the project was scheduled for deletion.
+func pendingDeletion(project *git.Project) bool {
+	for _, topic := range project.Topics {
+		if topic == pendingDeletionTopic {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleDeletion archives the project and records when it is to be
+// deleted, in the schedule first for the project to never be left pending
+// deletion on GitLab without being purged.
+func (s *Client) scheduleDeletion(ctx context.Context, project *git.Project, deadline time.Time) error {
+	if err := s.schedule.Add(ctx, project.ID, deadline); err != nil {
+		return fmt.Errorf("Could not record project deletion: %w", err)
+	}
+
+	topics := []string{pendingDeletionTopic, deleteAfterTopicPrefix + deadline.UTC().Format(deleteAfterLayout)}
+	for _, topic := range project.Topics {
+		if !retentionTopic(topic) {
+			topics = append(topics, topic)
+		}
+	}
+
+	if _, _, err := s.git().Projects.EditProject(project.ID, &git.EditProjectOptions{Topics: &topics}, git.WithContext(ctx)); err != nil {
+		return wrap("Could not schedule project deletion", err)
+	}
+
+	if !project.Archived {
+		if _, _, err := s.git().Projects.ArchiveProject(project.ID, git.WithContext(ctx)); err != nil {
+			return wrap("Could not archive project", err)
+		}
+	}
+
+	return nil
+}
+
+// restoreProject unarchives a project pending deletion and forgets about its
+// deletion, in the schedule first for the project to never be purged once
+// restored.
+func (s *Client) restoreProject(ctx context.Context, project *git.Project) error {
+	if err := s.schedule.Remove(ctx, project.ID); err != nil {
+		return fmt.Errorf("Could not forget project deletion: %w", err)
+	}
+
+	topics := []string{}
+	for _, topic := range project.Topics {
+		if !retentionTopic(topic) {
+			topics = append(topics, topic)
+		}
+	}
+
+	if project.Archived {
+		if _, _, err := s.git().Projects.UnarchiveProject(project.ID, git.WithContext(ctx)); err != nil {
+			return wrap("Could not unarchive project", err)
+		}
+	}
+
+	if _, _, err := s.git().Projects.EditProject(project.ID, &git.EditProjectOptions{Topics: &topics}, git.WithContext(ctx)); err != nil {
+		return wrap("Could not restore project", err)
+	}
+
+	return nil
+}
+
+// PurgeProjects deletes the projects pending deletion whose grace period ended
+// before now. Only the projects in the schedule are deleted, after the latest
+// of their scheduled deadline and the one of their topics, so that a project
+// can be kept longer but not deleted earlier from GitLab. Scheduled projects
+// no longer pending deletion once their deadline passed, restored or deleted
+// by hand, are forgotten. With dryRun, they are only reported.
+func (s *Client) PurgeProjects(ctx context.Context, now time.Time, dryRun bool) (error, []Change) {
+	var changes []Change
+
+	scheduled, err := s.schedule.Deadlines(ctx)
+	if err != nil {
+		return fmt.Errorf("Could not read the projects scheduled for deletion: %w", err), nil
+	}
+	if len(scheduled) == 0 {
+		return nil, nil
+	}
+
+	options := &git.ListProjectsOptions{
+		Topic:       git.String(pendingDeletionTopic),
+		ListOptions: git.ListOptions{PerPage: 100},
+	}
+
+	pending := map[int]bool{}
+	for {
+		projects, response, err := s.git().Projects.ListProjects(options, git.WithContext(ctx))
+		if err != nil {
+			return wrap("Could not list projects pending deletion", err), changes
+		}
+
+		for _, project := range projects {
+			deadline, ok := scheduled[project.ID]
+			if !ok {
+				continue
+			}
+			pending[project.ID] = true
+
+			if topicDeadline, ok := deletionDeadline(project.Topics); ok && topicDeadline.After(deadline) {
+				deadline = topicDeadline
+			}
+			if now.Before(deadline) || project.MarkedForDeletionAt != nil {
+				continue
+			}
+
+			if !dryRun {
+				_, err = s.git().Projects.DeleteProject(project.ID, git.WithContext(ctx))
+				err = wrap(fmt.Sprintf("Could not delete project %s", project.PathWithNamespace), err)
+				if err != nil && !backend.IsNotFound(err) {
+					return err, changes
+				}
+				s.Forget(project.PathWithNamespace)
+				if err := s.schedule.Remove(ctx, project.ID); err != nil {
+					return fmt.Errorf("Could not forget project deletion: %w", err), changes
+				}
+				if err != nil {
+					continue
+				}
+			}
+			changes = append(changes, Change{
+				Reason:  "ProjectDeleted",
+				Message: fmt.Sprintf("Deleted project %s (id %d), archived until %s", project.WebURL, project.ID, deadline.Format(time.RFC3339)),
+			})
+		}
+
+		if response.NextPage == 0 {
+			break
+		}
+		options.Page = response.NextPage
+	}
+
+	if dryRun {
+		return nil, changes
+	}
+
+	// Projects are recorded before being marked on GitLab, those not marked
+	// yet can only be forgotten once their deadline passed.
+	for id, deadline := range scheduled {
+		if pending[id] || now.Before(deadline) {
+			continue
+		}
+		if err := s.schedule.Remove(ctx, id); err != nil {
+			return fmt.Errorf("Could not forget project deletion: %w", err), changes
+		}
+	}
+
+	return nil, changes
+}
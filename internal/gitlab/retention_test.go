@@ -0,0 +1,141 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/gitlab/gitlabtest"
+)
+
+var _ = DescribeTable("deletionDeadline",
+	func(topics []string, deadline time.Time, ok bool) {
+		got, found := deletionDeadline(topics)
+		Expect(found).To(Equal(ok))
+		if ok {
+			Expect(got).To(BeTemporally("==", deadline))
+		}
+	},
+	Entry("without topics", nil, time.Time{}, false),
+	Entry("without a deadline", []string{pendingDeletionTopic, "infra"}, time.Time{}, false),
+	Entry("with a deadline", []string{"infra", deleteAfterTopicPrefix + "20230102T030405Z"}, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), true),
+	Entry("with a deadline lower cased by GitLab", []string{deleteAfterTopicPrefix + "20230102t030405z"}, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), true),
+	Entry("with a malformed deadline", []string{deleteAfterTopicPrefix + "tomorrow"}, time.Time{}, false),
+)
+
+var _ = Describe("Retention", func() {
+	var (
+		ctx      context.Context
+		server   *gitlabtest.Server
+		schedule *memorySchedule
+		client   *Client
+		now      time.Time
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = gitlabtest.NewServer()
+		DeferCleanup(server.Close)
+
+		schedule = newMemorySchedule()
+		var err error
+		client, err = NewInstance(server.URL, "token", Options{Schedule: schedule})
+		Expect(err).NotTo(HaveOccurred())
+
+		now = time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	// scheduled schedules the project at fullPath, added when missing, for
+	// deletion after deadline.
+	scheduled := func(fullPath string, deadline time.Time) *gitlabtest.Project {
+		if server.Project(fullPath) == nil {
+			server.AddProject(fullPath)
+		}
+		project, err := client.FindProjects(ctx, appv1.ProjectPath{Path: fullPath})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.scheduleDeletion(ctx, project, deadline)).To(Succeed())
+		return server.Project(fullPath)
+	}
+
+	It("archives and records the projects scheduled for deletion", func() {
+		server.AddProject("infra/scheduled").Topics = []string{"infra", deleteAfterTopicPrefix + "20230101T000000Z"}
+
+		project := scheduled("infra/scheduled", now)
+		Expect(project.Archived).To(BeTrue())
+		Expect(project.Topics).To(ConsistOf(pendingDeletionTopic, deleteAfterTopicPrefix+"20230601T120000Z", "infra"))
+		Expect(schedule.Deadlines(ctx)).To(Equal(map[int]time.Time{project.ID: now}))
+	})
+
+	It("restores the projects pending deletion", func() {
+		project := scheduled("infra/restored", now)
+		project.Topics = append(project.Topics, "infra")
+
+		found, err := client.FindProjects(ctx, appv1.ProjectPath{Path: "infra/restored"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.restoreProject(ctx, found)).To(Succeed())
+
+		project = server.Project("infra/restored")
+		Expect(project.Archived).To(BeFalse())
+		Expect(project.Topics).To(Equal([]string{"infra"}))
+		Expect(schedule.Deadlines(ctx)).To(BeEmpty())
+	})
+
+	It("deletes the scheduled projects whose deadline passed", func() {
+		expired := scheduled("infra/expired", now.Add(-time.Minute))
+		scheduled("infra/kept", now.Add(time.Minute))
+
+		err, changes := client.PurgeProjects(ctx, now, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(HaveLen(1))
+		Expect(server.Project("infra/expired")).To(BeNil())
+		Expect(server.Project("infra/kept")).NotTo(BeNil())
+		Expect(schedule.Deadlines(ctx)).NotTo(HaveKey(expired.ID))
+	})
+
+	It("never deletes the projects it did not schedule", func() {
+		project := server.AddProject("infra/foreign")
+		project.Topics = []string{pendingDeletionTopic, deleteAfterTopicPrefix + "20230101T000000Z"}
+		scheduled("infra/expired", now.Add(-time.Minute))
+
+		err, _ := client.PurgeProjects(ctx, now, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.Project("infra/foreign")).NotTo(BeNil())
+		Expect(server.Writes()).NotTo(ContainElement(fmt.Sprintf("DELETE /projects/%d", project.ID)))
+	})
+
+	It("keeps the projects whose topics postpone the deletion", func() {
+		project := scheduled("infra/postponed", now.Add(-time.Minute))
+		project.Topics = []string{pendingDeletionTopic, deleteAfterTopicPrefix + "20230602T000000Z"}
+
+		err, changes := client.PurgeProjects(ctx, now, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(BeEmpty())
+		Expect(server.Project("infra/postponed")).NotTo(BeNil())
+	})
+
+	It("only reports the deletions in dry-run", func() {
+		project := scheduled("infra/expired", now.Add(-time.Minute))
+
+		err, changes := client.PurgeProjects(ctx, now, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(HaveLen(1))
+		Expect(server.Project("infra/expired")).NotTo(BeNil())
+		Expect(schedule.Deadlines(ctx)).To(HaveKey(project.ID))
+	})
+
+	It("forgets the expired projects no longer pending deletion", func() {
+		restored := scheduled("infra/restored", now.Add(-time.Minute))
+		restored.Topics = []string{}
+		Expect(schedule.Add(ctx, 4242, now.Add(time.Minute))).To(Succeed())
+
+		err, changes := client.PurgeProjects(ctx, now, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(BeEmpty())
+		Expect(server.Project("infra/restored")).NotTo(BeNil())
+		Expect(schedule.Deadlines(ctx)).To(Equal(map[int]time.Time{4242: now.Add(time.Minute)}))
+	})
+})
@@ -0,0 +1,87 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/tracing"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		exporter *tracetest.InMemoryExporter
+		server   *httptest.Server
+		client   *Client
+		status   int
+	)
+
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+		status = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				fmt.Fprint(w, `[{"id": 42, "path_with_namespace": "infra/wellerman"}]`)
+			} else {
+				fmt.Fprint(w, `{"message": "403 Forbidden"}`)
+			}
+		}))
+		DeferCleanup(server.Close)
+
+		var err error
+		client, err = NewInstance(server.URL, "token", Options{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("traces GitLab requests as children of the reconciliation", func() {
+		var lines []string
+		ctx := log.IntoContext(context.Background(), funcr.New(func(prefix, args string) {
+			lines = append(lines, args)
+		}, funcr.Options{}))
+
+		ctx, span := tracing.Start(ctx, "Project reconcile")
+		log.FromContext(ctx).Info("Reconciling Project.")
+		project, err := client.FindProjects(ctx, appv1.ProjectPath{Path: "infra/wellerman"})
+		tracing.End(span, err)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(project.ID).To(Equal(42))
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(2))
+
+		request, reconcile := spans[0], spans[1]
+		Expect(reconcile.Name).To(Equal("Project reconcile"))
+		Expect(request.Name).To(Equal("gitlab GET /projects"))
+		Expect(request.Parent.SpanID()).To(Equal(reconcile.SpanContext.SpanID()))
+		Expect(request.SpanContext.TraceID()).To(Equal(reconcile.SpanContext.TraceID()))
+
+		Expect(lines).To(ContainElement(ContainSubstring(reconcile.SpanContext.TraceID().String())))
+	})
+
+	It("records the errors GitLab answers with", func() {
+		status = http.StatusForbidden
+
+		_, err := client.FindProjects(context.Background(), appv1.ProjectPath{Path: "infra/wellerman"})
+		Expect(err).To(HaveOccurred())
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Status.Code).To(Equal(codes.Error))
+	})
+})
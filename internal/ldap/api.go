@@ -1,46 +1,307 @@
 package ldap
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+
 	appv1 "github.com/vbouchaud/wellerman/api/v1"
-	"reflect"
+	"github.com/vbouchaud/wellerman/internal/backend"
+	"github.com/vbouchaud/wellerman/internal/dn"
 )
 
-func (s *Client) ReconcileGroup(team *appv1.Team) (string, error, bool) {
-	groupDN := fmt.Sprintf("%s=%s,%s", s.groupNameProperty, team.Name, s.groupSearchBase)
+// Change is a write wellerman performed on the directory, for it to be
+// reported.
+type Change struct {
+	// Reason is a short CamelCase name of the change, such as GroupCreated.
+	Reason string
+	// Message describes the change and names its target.
+	Message string
+}
+
+// membersDiff returns the members to add to and to delete from a group
+// currently holding current. Unless the policy is exclusive, only members
+// previously managed by wellerman are deleted. ErrEmptyGroup is returned when
+// the group would be left without any member, which its schema forbids.
+func membersDiff(current, desired, managed []string, policy appv1.MembershipPolicy) (add, del []string, err error) {
+	currentSet := toSet(current)
+	desiredSet := toSet(desired)
+	managedSet := toSet(managed)
+
+	for _, member := range desired {
+		if !currentSet[strings.ToLower(member)] {
+			add = append(add, member)
+		}
+	}
+
+	for _, member := range current {
+		key := strings.ToLower(member)
+		if desiredSet[key] {
+			continue
+		}
+		if policy == appv1.MembershipAdditive && !managedSet[key] {
+			continue
+		}
+		del = append(del, member)
+	}
+
+	if len(current)-len(del)+len(add) == 0 {
+		return nil, nil, ErrEmptyGroup
+	}
+
+	return add, del, nil
+}
+
+func toSet(a []string) map[string]bool {
+	m := make(map[string]bool, len(a))
+	for _, item := range a {
+		m[strings.ToLower(item)] = true
+	}
+	return m
+}
+
+type groupDNData struct {
+	GroupName    string
+	Name         string
+	Namespace    string
+	Labels       map[string]string
+	Annotations  map[string]string
+	NameProperty string
+	SearchBase   string
+}
+
+// GroupDN returns the DN of the group named groupName for the Team, as given by
+// the group DN template. The values taken from the Team are escaped, and the
+// DN must be under the group search base, for a Team not to place its group
+// anywhere else in the directory.
+func (s *Client) GroupDN(team appv1.TeamObject, groupName string) (string, error) {
+	var b strings.Builder
+
+	if err := s.groupDNTemplate.Execute(&b, groupDNData{
+		GroupName:    dn.EscapeValue(groupName),
+		Name:         dn.EscapeValue(team.GetName()),
+		Namespace:    dn.EscapeValue(team.GetNamespace()),
+		Labels:       escapeDNValues(team.GetLabels()),
+		Annotations:  escapeDNValues(team.GetAnnotations()),
+		NameProperty: s.groupNameProperty,
+		SearchBase:   s.groupSearchBase,
+	}); err != nil {
+		return "", backend.Wrap(backend.Invalid, "could not build group DN", err)
+	}
+	groupDN := b.String()
+
+	parsed, err := ldapv3.ParseDN(groupDN)
+	if err != nil {
+		return "", backend.Wrap(backend.Invalid, fmt.Sprintf("group DN %q is invalid", groupDN), err)
+	}
+
+	if s.groupSearchBase != "" {
+		base, err := ldapv3.ParseDN(s.groupSearchBase)
+		if err != nil {
+			return "", backend.Wrap(backend.Invalid, fmt.Sprintf("group search base %q is invalid", s.groupSearchBase), err)
+		}
+		if !base.AncestorOfFold(parsed) {
+			return "", backend.New(backend.Invalid, fmt.Sprintf("group DN %q is not under the group search base %q", groupDN, s.groupSearchBase))
+		}
+	}
+
+	return groupDN, nil
+}
+
+// LegacyGroupDN returns the DN the group of the Team was created at before the
+// group DN template existed, directly under the group search base and named
+// after the Team, whatever the template now is.
+func (s *Client) LegacyGroupDN(team appv1.TeamObject) string {
+	return fmt.Sprintf("%s=%s,%s", s.groupNameProperty, dn.EscapeValue(team.GetName()), s.groupSearchBase)
+}
+
+func escapeDNValues(values map[string]string) map[string]string {
+	escaped := make(map[string]string, len(values))
+	for key, value := range values {
+		escaped[key] = dn.EscapeValue(value)
+	}
+	return escaped
+}
+
+// ReconcileGroup ensures the group named groupName holds members. The status of
+// the Team is expected to hold the members wellerman may have added so far,
+// which are the only ones removed with the Additive policy. previous is where
+// the group was, it is moved when the DN template now gives another DN. With
+// dryRun, the changes are computed and returned but not written.
+func (s *Client) ReconcileGroup(ctx context.Context, team appv1.TeamObject, groupName, previous string, members []string, dryRun bool) (string, error, []Change) {
+	spec, status := team.GetSpec(), team.GetStatus()
 
-	exists, err, entry := s.groupExists(team.Name)
+	groupDN, err := s.GroupDN(team, groupName)
 	if err != nil {
-		return groupDN, err, false
+		return previous, err, nil
+	}
+
+	entry, err := s.getEntry(ctx, groupDN)
+	if err != nil {
+		return groupDN, err, nil
+	}
+
+	var changes []Change
+
+	if entry == nil && previous != "" && !strings.EqualFold(previous, groupDN) {
+		if entry, err = s.getEntry(ctx, previous); err != nil {
+			return previous, err, nil
+		}
+
+		if entry != nil {
+			if !s.owned(entry) && !mayAdopt(team, previous) {
+				return previous, ErrGroupNotOwned, nil
+			}
+			err, parentChanges := s.ensureParents(ctx, groupDN, dryRun)
+			changes = append(changes, parentChanges...)
+			if err != nil {
+				return previous, err, changes
+			}
+			if err = s.write(dryRun, func() error { return s.moveGroup(ctx, previous, groupDN) }); err != nil {
+				return previous, err, changes
+			}
+			changes = append(changes, Change{
+				Reason:  "GroupMoved",
+				Message: fmt.Sprintf("Moved group %s to %s", previous, groupDN),
+			})
+		}
 	}
 
-	if exists {
-		changed := entry.GetAttributeValue(description) != team.Spec.Comment || !reflect.DeepEqual(sanitize(entry.GetAttributeValues(uniqueMember)), sanitize(team.Spec.Subjects))
-		if changed {
-			return groupDN, s.modifyGroup(groupDN, team.Spec.Comment, team.Spec.Subjects), true
+	wanted := s.description(spec.Comment)
+
+	if entry != nil {
+		owned := s.owned(entry)
+		if !owned && !mayAdopt(team, entry.DN) {
+			return groupDN, ErrGroupNotOwned, changes
+		}
+
+		var desc *string
+		if entry.GetAttributeValue(description) != wanted {
+			desc = &wanted
+		}
+
+		add, del, err := membersDiff(entry.GetAttributeValues(s.schema.MemberAttribute), members, status.Members, spec.MembershipPolicy)
+		if err != nil {
+			return groupDN, err, changes
+		}
+		if owned && desc == nil && len(add) == 0 && len(del) == 0 {
+			return groupDN, nil, changes
+		}
+
+		if err = s.write(dryRun, func() error { return s.modifyGroup(ctx, groupDN, desc, add, del, !owned) }); err != nil {
+			return groupDN, err, changes
 		}
-		return groupDN, nil, false
+
+		if !owned {
+			changes = append(changes, Change{Reason: "GroupAdopted", Message: fmt.Sprintf("Adopted group %s", groupDN)})
+		}
+		if desc != nil {
+			changes = append(changes, Change{Reason: "GroupUpdated", Message: fmt.Sprintf("Set the description of %s to %q", groupDN, wanted)})
+		}
+		if len(add) > 0 {
+			changes = append(changes, Change{Reason: "MembersAdded", Message: fmt.Sprintf("Added to %s: %s", groupDN, strings.Join(add, "; "))})
+		}
+		if len(del) > 0 {
+			changes = append(changes, Change{Reason: "MembersRemoved", Message: fmt.Sprintf("Removed from %s: %s", groupDN, strings.Join(del, "; "))})
+		}
+
+		return groupDN, nil, changes
+	}
+
+	if len(members) == 0 {
+		return groupDN, ErrEmptyGroup, changes
+	}
+
+	err, parentChanges := s.ensureParents(ctx, groupDN, dryRun)
+	changes = append(changes, parentChanges...)
+	if err != nil {
+		return groupDN, err, changes
 	}
 
-	return groupDN, s.createGroup(groupDN, team.Spec.Comment, team.Spec.Subjects), true
+	if err = s.write(dryRun, func() error { return s.createGroup(ctx, groupDN, wanted, members) }); err != nil {
+		return groupDN, err, changes
+	}
+
+	return groupDN, nil, append(changes, Change{
+		Reason:  "GroupCreated",
+		Message: fmt.Sprintf("Created group %s with %d members", groupDN, len(members)),
+	})
+}
+
+// mayAdopt tells whether the group at dn may be marked as managed by wellerman
+// and managed: when the Team asks for it, or when wellerman already manages
+// the group for the Team, as recorded in its status, without having marked it.
+func mayAdopt(team appv1.TeamObject, dn string) bool {
+	return team.GetSpec().AdoptionPolicy == appv1.AdoptionAdopt || strings.EqualFold(team.GetStatus().DistinguishedName, dn)
+}
+
+// DeleteGroup removes the group at groupDN, provided it is managed by wellerman.
+// A group that is already gone, along with its parents or not, is not an
+// error. With dryRun, the group is only checked.
+func (s *Client) DeleteGroup(ctx context.Context, groupDN string, dryRun bool) (error, []Change) {
+	entry, err := s.getEntry(ctx, groupDN)
+	if err != nil {
+		return err, nil
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	if !s.owned(entry) {
+		return ErrGroupNotOwned, nil
+	}
+
+	err = s.write(dryRun, func() error { return s.deleteGroup(ctx, groupDN) })
+	if errors.Is(err, ErrGroupNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return err, nil
+	}
+
+	return nil, []Change{{
+		Reason:  "GroupDeleted",
+		Message: fmt.Sprintf("Deleted group %s", groupDN),
+	}}
 }
 
-func (s *Client) DeleteGroup(name string) error {
-	groupDN := fmt.Sprintf("%s=%s,%s", s.groupNameProperty, name, s.groupSearchBase)
+// ResolveUser returns the DN of the user with the given login.
+func (s *Client) ResolveUser(ctx context.Context, login string) (string, error) {
+	return s.findUser(ctx, s.userSearch.Filter, login)
+}
+
+// ResolveEmail returns the DN of the user with the given mail address.
+func (s *Client) ResolveEmail(ctx context.Context, email string) (string, error) {
+	return s.findUser(ctx, s.userSearch.EmailFilter, email)
+}
 
-	exists, err, _ := s.groupExists(name)
+// SearchUsers returns the DN of every user matching filter.
+func (s *Client) SearchUsers(ctx context.Context, filter string) ([]string, error) {
+	entries, err := s.searchUsers(ctx, filter)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if !exists {
-		return errors.New(errGroupNotFound)
+	dns := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		dns = append(dns, entry.DN)
 	}
 
-	return s.deleteGroup(groupDN)
+	return dns, nil
 }
 
+// IsNotFound tells whether err was returned because a group or a user does not
+// exist.
 func IsNotFound(err error) bool {
-	return err.Error() == errGroupNotFound
+	return errors.Is(err, ErrGroupNotFound) || errors.Is(err, ErrUserNotFound)
+}
+
+// IsNotOwned tells whether err was returned because the group is not managed
+// by wellerman.
+func IsNotOwned(err error) bool {
+	return errors.Is(err, ErrGroupNotOwned)
 }
@@ -0,0 +1,120 @@
+package ldap
+
+import (
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appv1 "github.com/vbouchaud/wellerman/api/v1"
+	"github.com/vbouchaud/wellerman/internal/backend"
+)
+
+var _ = Describe("membersDiff", func() {
+	const (
+		jdoe   = "uid=jdoe,ou=people,dc=example,dc=org"
+		asmith = "uid=asmith,ou=people,dc=example,dc=org"
+		bking  = "uid=bking,ou=people,dc=example,dc=org"
+	)
+
+	DescribeTable("computes the members to add and delete",
+		func(current, desired, managed []string, policy appv1.MembershipPolicy, add, del []string) {
+			gotAdd, gotDel, err := membersDiff(current, desired, managed, policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gotAdd).To(Equal(add))
+			Expect(gotDel).To(Equal(del))
+		},
+		Entry("exclusive, nothing to do",
+			[]string{jdoe}, []string{jdoe}, nil, appv1.MembershipExclusive, nil, nil),
+		Entry("exclusive, DNs compared without case",
+			[]string{"UID=jdoe,ou=people,dc=example,dc=org"}, []string{jdoe}, nil, appv1.MembershipExclusive, nil, nil),
+		Entry("exclusive, undeclared members removed",
+			[]string{jdoe, asmith}, []string{jdoe, bking}, nil, appv1.MembershipExclusive, []string{bking}, []string{asmith}),
+		Entry("additive, undeclared members kept",
+			[]string{jdoe, asmith}, []string{bking}, nil, appv1.MembershipAdditive, []string{bking}, nil),
+		Entry("additive, members no longer declared removed when managed",
+			[]string{jdoe, asmith}, []string{jdoe}, []string{jdoe, asmith}, appv1.MembershipAdditive, nil, []string{asmith}),
+		Entry("additive, managed set compared without case",
+			[]string{jdoe, asmith}, []string{jdoe}, []string{"UID=asmith,OU=people,dc=example,dc=org"}, appv1.MembershipAdditive, nil, []string{asmith}),
+	)
+
+	DescribeTable("refuses to leave the group without any member",
+		func(current, desired, managed []string, policy appv1.MembershipPolicy) {
+			_, _, err := membersDiff(current, desired, managed, policy)
+			Expect(err).To(MatchError(ErrEmptyGroup))
+		},
+		Entry("exclusive, nobody declared", []string{jdoe, asmith}, nil, nil, appv1.MembershipExclusive),
+		Entry("additive, every managed member removed", []string{jdoe}, nil, []string{jdoe}, appv1.MembershipAdditive),
+		Entry("empty group, nobody declared", nil, nil, nil, appv1.MembershipExclusive),
+	)
+})
+
+var _ = Describe("mayAdopt", func() {
+	const dn = "cn=ops,ou=groups,dc=example,dc=org"
+
+	DescribeTable("only adopts groups when asked to, or already managed",
+		func(policy appv1.AdoptionPolicy, recorded string, adopt bool) {
+			team := &appv1.Team{
+				Spec:   appv1.TeamSpec{AdoptionPolicy: policy},
+				Status: appv1.TeamStatus{DistinguishedName: recorded},
+			}
+			Expect(mayAdopt(team, dn)).To(Equal(adopt))
+		},
+		Entry("Adopt", appv1.AdoptionAdopt, "", true),
+		Entry("Observe", appv1.AdoptionObserve, "", false),
+		Entry("Refuse", appv1.AdoptionRefuse, "", false),
+		Entry("unset", appv1.AdoptionPolicy(""), "", false),
+		Entry("Observe, group already managed for the Team", appv1.AdoptionObserve, "CN=ops,ou=groups,dc=example,dc=org", true),
+		Entry("Observe, another group managed for the Team", appv1.AdoptionObserve, "cn=sre,ou=groups,dc=example,dc=org", false),
+	)
+})
+
+var _ = Describe("GroupDN", func() {
+	newClient := func(template string) *Client {
+		s, err := NewInstance("ldaps://ldap.example.org", "cn=admin,dc=example,dc=org", "secret",
+			"ou=groups,dc=example,dc=org", "cn", template, nil,
+			UserSearchOptions{}, SchemaOptions{}, OwnershipOptions{}, TLSOptions{}, PoolOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(s.Close)
+		return s
+	}
+
+	newTeam := func(annotations map[string]string) *appv1.Team {
+		team := &appv1.Team{}
+		team.Name, team.Namespace, team.Annotations = "ops", "infra", annotations
+		return team
+	}
+
+	It("places groups under the search base by default", func() {
+		dn, err := newClient("").GroupDN(newTeam(nil), "ops")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dn).To(Equal("cn=ops,ou=groups,dc=example,dc=org"))
+	})
+
+	It("escapes the values taken from the Team", func() {
+		s := newClient(`cn={{.GroupName}},ou={{index .Annotations "team"}},{{.SearchBase}}`)
+
+		dn, err := s.GroupDN(newTeam(map[string]string{"team": "x,ou=admins+cn=root"}), "ops,ou=admins")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dn).To(Equal(`cn=ops\,ou\=admins,ou=x\,ou\=admins\+cn\=root,ou=groups,dc=example,dc=org`))
+
+		parsed, err := ldapv3.ParseDN(dn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed.RDNs).To(HaveLen(5))
+		Expect(parsed.RDNs[0].Attributes).To(HaveLen(1))
+		Expect(parsed.RDNs[0].Attributes[0].Value).To(Equal("ops,ou=admins"))
+	})
+
+	It("finds legacy groups under the search base whatever the template", func() {
+		s := newClient(`cn={{.GroupName}},ou={{.Namespace}},{{.SearchBase}}`)
+
+		Expect(s.LegacyGroupDN(newTeam(nil))).To(Equal("cn=ops,ou=groups,dc=example,dc=org"))
+	})
+
+	It("refuses DNs outside of the search base", func() {
+		s := newClient(`cn={{.GroupName}},ou=admins,dc=example,dc=org`)
+
+		_, err := s.GroupDN(newTeam(nil), "ops")
+		Expect(err).To(MatchError(ContainSubstring("not under the group search base")))
+		Expect(backend.KindOf(err)).To(Equal(backend.Invalid))
+	})
+})
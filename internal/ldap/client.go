@@ -1,11 +1,23 @@
 package ldap
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"sort"
+	"net"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
+	"text/template"
 
 	ldapv3 "github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+	"github.com/vbouchaud/wellerman/internal/tracing"
 )
 
 const (
@@ -21,27 +33,92 @@ var scopeMap = map[string]int{
 }
 
 const (
-	objectClass  = "objectClass"
-	uniqueMember = "uniqueMember"
-	description  = "description"
+	objectClass = "objectClass"
+	description = "description"
 
-	groupClassValue = "groupOfUniqueNames"
+	ouClassValue = "organizationalUnit"
+	ouAttribute  = "ou"
 )
 
+// DefaultGroupDNTemplate places every group directly under the group search base.
+const DefaultGroupDNTemplate = "{{.NameProperty}}={{.GroupName}},{{.SearchBase}}"
+
 const (
-	errGroupNotFound     = "group name was not found"
 	errGroupNotExtracted = "group name could not be extracted"
+	errInsecureBind      = "refusing to send bind credentials over an unencrypted connection, use ldaps:// or StartTLS"
+	errInvalidCA         = "no certificate could be parsed from the CA bundle"
+	errNotAnOU           = "parent entry is missing and is not an organizational unit"
 )
 
+const DefaultDescriptionPrefix = "[wellerman] "
+
+// OwnershipOptions configures the marker written on the groups managed by
+// wellerman. The marker is Value in Attribute when Attribute is set, or
+// DescriptionPrefix at the start of the description otherwise.
+type OwnershipOptions struct {
+	Attribute         string
+	Value             string
+	DescriptionPrefix string
+}
+
+const (
+	DefaultGroupObjectClass = "groupOfUniqueNames"
+	DefaultMemberAttribute  = "uniqueMember"
+)
+
+// SchemaOptions describes how groups are stored in the directory.
+type SchemaOptions struct {
+	GroupObjectClass string
+	MemberAttribute  string
+}
+
+// UserSearchOptions configures how members are looked up in the directory.
+type UserSearchOptions struct {
+	Base   string
+	Scope  string
+	Filter string
+	// EmailFilter selects a user from its mail address.
+	EmailFilter string
+}
+
+// TLSOptions configures how the connection to the LDAP server is secured.
+type TLSOptions struct {
+	// StartTLS upgrades a plain ldap:// connection before binding.
+	StartTLS bool
+	// CAFile is a PEM bundle used instead of the system roots to verify the server.
+	// CA holds the bundle itself, when not read from a file.
+	CAFile string
+	CA     []byte
+	// CertFile and KeyFile are the client certificate presented to the server.
+	// Cert and Key hold the certificate itself, when not read from files.
+	CertFile string
+	KeyFile  string
+	Cert     []byte
+	Key      []byte
+	// ServerName overrides the name used to verify the server certificate.
+	ServerName string
+	// InsecureSkipVerify disables server certificate verification, labs only.
+	InsecureSkipVerify bool
+	// SASLExternal binds with the client certificate instead of bindDN/bindPassword.
+	SASLExternal bool
+}
+
 type Client struct {
 	ldapURL               string
 	bindDN                string
+	bindPasswordMu        sync.RWMutex
 	bindPassword          string
 	groupSearchBase       string
-	groupSearchScope      string
-	groupSearchFilter     string
 	groupNameProperty     string
+	groupDNTemplate       *template.Template
 	groupSearchAttributes []string
+	userSearch            UserSearchOptions
+	schema                SchemaOptions
+	ownership             OwnershipOptions
+	startTLS              bool
+	saslExternal          bool
+	tlsConfig             *tls.Config
+	pool                  *pool
 }
 
 func NewInstance(
@@ -49,137 +126,373 @@ func NewInstance(
 	bindDN,
 	bindPassword,
 	groupSearchBase,
-	groupSearchScope,
-	groupSearchFilter,
-	groupNameProperty string,
+	groupNameProperty,
+	groupDNTemplate string,
 	groupSearchAttributes []string,
-) *Client {
+	userSearch UserSearchOptions,
+	schema SchemaOptions,
+	ownership OwnershipOptions,
+	tlsOptions TLSOptions,
+	poolOptions PoolOptions,
+) (*Client, error) {
+	tlsConfig, err := newTLSConfig(ldapURL, tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if schema.GroupObjectClass == "" {
+		schema.GroupObjectClass = DefaultGroupObjectClass
+	}
+	if schema.MemberAttribute == "" {
+		schema.MemberAttribute = DefaultMemberAttribute
+	}
+
+	if groupDNTemplate == "" {
+		groupDNTemplate = DefaultGroupDNTemplate
+	}
+	dnTemplate, err := template.New("group-dn").Option("missingkey=error").Parse(groupDNTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse group DN template: %w", err)
+	}
+
 	s := &Client{
 		ldapURL:               ldapURL,
 		bindDN:                bindDN,
 		bindPassword:          bindPassword,
 		groupSearchBase:       groupSearchBase,
-		groupSearchScope:      groupSearchScope,
-		groupSearchFilter:     groupSearchFilter,
 		groupNameProperty:     groupNameProperty,
+		groupDNTemplate:       dnTemplate,
 		groupSearchAttributes: groupSearchAttributes,
+		userSearch:            userSearch,
+		schema:                schema,
+		ownership:             ownership,
+		startTLS:              tlsOptions.StartTLS,
+		saslExternal:          tlsOptions.SASLExternal,
+		tlsConfig:             tlsConfig,
 	}
+	s.pool = newPool(s.bind, poolOptions)
 
-	return s
+	return s, nil
 }
 
-func (s *Client) bind() (*ldapv3.Conn, error) {
-	l, err := ldapv3.DialURL(s.ldapURL)
-	if err != nil {
-		return nil, err
+// Close closes the connections of the pool, the ones in use once they are
+// released.
+func (s *Client) Close() {
+	s.pool.close()
+}
+
+// SetBindPassword replaces the password used to bind. Connections bound with
+// the previous one are closed once they are no longer in use.
+func (s *Client) SetBindPassword(password string) {
+	s.bindPasswordMu.Lock()
+	s.bindPassword = password
+	s.bindPasswordMu.Unlock()
+
+	s.pool.reset()
+}
+
+func newTLSConfig(ldapURL string, o TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
 	}
 
-	err = l.Bind(s.bindDN, s.bindPassword)
+	// StartTLS does not derive the server name from the dialed address.
+	if config.ServerName == "" {
+		u, err := url.Parse(ldapURL)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = u.Host
+		if host, _, err := net.SplitHostPort(u.Host); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %w", err)
+		}
+		o.CA = pem
+	}
+
+	if len(o.CA) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(o.CA) {
+			return nil, errors.New(errInvalidCA)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if len(o.Cert) > 0 || len(o.Key) > 0 {
+		cert, err := tls.X509KeyPair(o.Cert, o.Key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func (s *Client) bind(ctx context.Context) (l *ldapv3.Conn, err error) {
+	_, span := tracing.Start(ctx, "ldap bind", attribute.String("ldap.url", s.ldapURL))
+	defer func() { tracing.End(span, err) }()
+
+	l, err = ldapv3.DialURL(s.ldapURL, ldapv3.DialWithTLSConfig(s.tlsConfig))
 	if err != nil {
 		return nil, err
 	}
 
-	return l, nil
-}
+	if s.startTLS {
+		if err = l.StartTLS(s.tlsConfig); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	if s.saslExternal {
+		err = l.ExternalBind()
+	} else if _, ok := l.TLSConnectionState(); !ok {
+		err = backend.New(backend.Invalid, errInsecureBind)
+	} else {
+		s.bindPasswordMu.RLock()
+		password := s.bindPassword
+		s.bindPasswordMu.RUnlock()
+
+		err = l.Bind(s.bindDN, password)
+	}
 
-func (s *Client) groupExists(name string) (bool, error, *ldapv3.Entry) {
-	l, err := s.bind()
 	if err != nil {
-		return false, err, nil
+		l.Close()
+		return nil, err
 	}
 
-	defer l.Close()
+	return l, nil
+}
 
+// getEntry returns the entry at dn, or nil when there is none.
+func (s *Client) getEntry(ctx context.Context, dn string) (*ldapv3.Entry, error) {
 	searchRequest := ldapv3.NewSearchRequest(
-		s.groupSearchBase,
-		scopeMap[s.groupSearchScope],
+		dn,
+		ldapv3.ScopeBaseObject,
 		ldapv3.NeverDerefAliases,
 		0,
 		0,
 		false,
-		fmt.Sprintf(s.groupSearchFilter, name),
+		"(objectClass=*)",
 		s.groupSearchAttributes,
 		nil,
 	)
-	result, err := l.Search(searchRequest)
-	if err != nil {
-		return false, err, nil
+
+	var result *ldapv3.SearchResult
+	err := s.withConn(ctx, "search", func(l *ldapv3.Conn) (err error) {
+		result, err = l.Search(searchRequest)
+		return
+	})
+	if backend.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
 
 	if len(result.Entries) == 0 {
-		return false, nil, nil
-	} else if len(result.Entries) > 1 {
-		return false, fmt.Errorf("too many entries returned"), nil
+		return nil, nil
 	}
 
-	return true, nil, result.Entries[0]
+	return result.Entries[0], nil
 }
 
-func (s *Client) createGroup(groupDN, desc string, members []string) error {
-	l, err := s.bind()
+// ensureParents creates the organizational units missing between dn and its
+// closest existing ancestor.
+func (s *Client) ensureParents(ctx context.Context, dn string, dryRun bool) (error, []Change) {
+	parsed, err := ldapv3.ParseDN(dn)
 	if err != nil {
-		return err
+		return err, nil
 	}
 
-	defer l.Close()
+	var missing []*ldapv3.DN
+	for i := 1; i < len(parsed.RDNs); i++ {
+		parent := &ldapv3.DN{RDNs: parsed.RDNs[i:]}
 
-	addRequest := ldapv3.NewAddRequest(groupDN, nil)
-	addRequest.Attribute(objectClass, []string{groupClassValue})
-	addRequest.Attribute(description, []string{desc})
-	addRequest.Attribute(uniqueMember, members)
+		entry, err := s.getEntry(ctx, parent.String())
+		if err != nil {
+			return err, nil
+		}
+		if entry != nil {
+			break
+		}
 
-	if err := l.Add(addRequest); err != nil {
-		return err
+		missing = append(missing, parent)
+	}
+
+	var changes []Change
+	for i := len(missing) - 1; i >= 0; i-- {
+		rdn := missing[i].RDNs[0].Attributes[0]
+		if !strings.EqualFold(rdn.Type, ouAttribute) {
+			return backend.Wrap(backend.Invalid, errNotAnOU, errors.New(missing[i].String())), changes
+		}
+
+		addRequest := ldapv3.NewAddRequest(missing[i].String(), nil)
+		addRequest.Attribute(objectClass, []string{ouClassValue})
+		addRequest.Attribute(ouAttribute, []string{rdn.Value})
+
+		if err := s.write(dryRun, func() error {
+			return s.withConn(ctx, "add", func(l *ldapv3.Conn) error {
+				return l.Add(addRequest)
+			})
+		}); err != nil {
+			return err, changes
+		}
+
+		changes = append(changes, Change{
+			Reason:  "OrganizationalUnitCreated",
+			Message: fmt.Sprintf("Created organizational unit %s", missing[i]),
+		})
 	}
 
-	return nil
+	return nil, changes
+}
+
+// write runs fn unless dryRun is set.
+func (s *Client) write(dryRun bool, fn func() error) error {
+	if dryRun {
+		return nil
+	}
+	return fn()
 }
 
-func (s *Client) modifyGroup(groupDN, desc string, members []string) error {
-	l, err := s.bind()
+// moveGroup renames the group at oldDN to newDN, possibly under a new parent.
+func (s *Client) moveGroup(ctx context.Context, oldDN, newDN string) error {
+	parsed, err := ldapv3.ParseDN(newDN)
 	if err != nil {
 		return err
 	}
 
-	defer l.Close()
+	rdn := parsed.RDNs[0].String()
+	superior := (&ldapv3.DN{RDNs: parsed.RDNs[1:]}).String()
 
-	modifyRequest := ldapv3.NewModifyRequest(groupDN, nil)
-	modifyRequest.Replace(objectClass, []string{groupClassValue})
-	modifyRequest.Replace(description, []string{desc})
-	modifyRequest.Replace(uniqueMember, members)
+	modifyDNRequest := ldapv3.NewModifyDNRequest(oldDN, rdn, true, superior)
 
-	if err := l.Modify(modifyRequest); err != nil {
-		return err
+	return s.withConn(ctx, "modify_dn", func(l *ldapv3.Conn) error {
+		return l.ModifyDN(modifyDNRequest)
+	})
+}
+
+func (s *Client) searchUsers(ctx context.Context, filter string) ([]*ldapv3.Entry, error) {
+	searchRequest := ldapv3.NewSearchRequest(
+		s.userSearch.Base,
+		scopeMap[s.userSearch.Scope],
+		ldapv3.NeverDerefAliases,
+		0,
+		0,
+		false,
+		filter,
+		[]string{"dn"},
+		nil,
+	)
+
+	var result *ldapv3.SearchResult
+	err := s.withConn(ctx, "search", func(l *ldapv3.Conn) (err error) {
+		result, err = l.SearchWithPaging(searchRequest, 500)
+		return
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return result.Entries, nil
 }
 
-func (s *Client) deleteGroup(groupDN string) error {
-	l, err := s.bind()
+func (s *Client) findUser(ctx context.Context, filter, value string) (string, error) {
+	entries, err := s.searchUsers(ctx, fmt.Sprintf(filter, ldapv3.EscapeFilter(value)))
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	defer l.Close()
+	if len(entries) == 0 {
+		return "", ErrUserNotFound
+	} else if len(entries) > 1 {
+		return "", backend.New(backend.Invalid, "too many entries returned")
+	}
 
-	delRequest := ldapv3.NewDelRequest(groupDN, nil)
+	return entries[0].DN, nil
+}
 
-	if err := l.Del(delRequest); err != nil {
-		return err
+func (s *Client) createGroup(ctx context.Context, groupDN, desc string, members []string) error {
+	addRequest := ldapv3.NewAddRequest(groupDN, nil)
+	addRequest.Attribute(objectClass, []string{s.schema.GroupObjectClass})
+	addRequest.Attribute(description, []string{desc})
+	addRequest.Attribute(s.schema.MemberAttribute, members)
+	if s.ownership.Attribute != "" {
+		addRequest.Attribute(s.ownership.Attribute, []string{s.ownership.Value})
 	}
 
-	return nil
+	return s.withConn(ctx, "add", func(l *ldapv3.Conn) error {
+		return l.Add(addRequest)
+	})
 }
 
-func sanitize(a []string) []string {
-	var res []string
+// modifyGroup updates the description when desc is set, and adds and deletes
+// the given members. Members are added first so that the group never ends up
+// without any member. With mark, the ownership attribute is added.
+func (s *Client) modifyGroup(ctx context.Context, groupDN string, desc *string, add, del []string, mark bool) error {
+	modifyRequest := ldapv3.NewModifyRequest(groupDN, nil)
+	if mark && s.ownership.Attribute != "" {
+		modifyRequest.Add(s.ownership.Attribute, []string{s.ownership.Value})
+	}
+	if desc != nil {
+		modifyRequest.Replace(description, []string{*desc})
+	}
+	if len(add) > 0 {
+		modifyRequest.Add(s.schema.MemberAttribute, add)
+	}
+	if len(del) > 0 {
+		modifyRequest.Delete(s.schema.MemberAttribute, del)
+	}
 
-	for _, item := range a {
-		res = append(res, strings.ToLower(item))
+	return s.withConn(ctx, "modify", func(l *ldapv3.Conn) error {
+		return l.Modify(modifyRequest)
+	})
+}
+
+func (s *Client) description(comment string) string {
+	if s.ownership.Attribute != "" {
+		return comment
+	}
+	return s.ownership.DescriptionPrefix + comment
+}
+
+func (s *Client) owned(entry *ldapv3.Entry) bool {
+	if s.ownership.Attribute == "" {
+		return strings.HasPrefix(entry.GetAttributeValue(description), s.ownership.DescriptionPrefix)
+	}
+
+	for _, value := range entry.GetAttributeValues(s.ownership.Attribute) {
+		if strings.EqualFold(value, s.ownership.Value) {
+			return true
+		}
 	}
 
-	sort.Strings(res)
+	return false
+}
+
+func (s *Client) deleteGroup(ctx context.Context, groupDN string) error {
+	delRequest := ldapv3.NewDelRequest(groupDN, nil)
+
+	err := s.withConn(ctx, "delete", func(l *ldapv3.Conn) error {
+		return l.Del(delRequest)
+	})
+	if backend.IsNotFound(err) {
+		return ErrGroupNotFound
+	}
 
-	return res
+	return err
 }
@@ -0,0 +1,79 @@
+package ldap
+
+import (
+	"errors"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+)
+
+var (
+	// ErrGroupNotFound is returned when the group to work on does not exist.
+	ErrGroupNotFound = backend.New(backend.NotFound, "group name was not found")
+	// ErrUserNotFound is returned when no user matches a login or an email.
+	ErrUserNotFound = backend.New(backend.NotFound, "user was not found")
+	// ErrGroupNotOwned is returned when the group exists but was not created,
+	// nor adopted, by wellerman.
+	ErrGroupNotOwned = backend.New(backend.Conflict, "group exists and is not managed by wellerman")
+	// ErrEmptyGroup is returned rather than leaving a group without any
+	// member.
+	ErrEmptyGroup = backend.New(backend.Invalid, "group would be left without any member")
+)
+
+// resultKinds classifies the LDAP result codes that are not Unknown.
+var resultKinds = map[uint16]backend.Kind{
+	ldapv3.LDAPResultNoSuchObject: backend.NotFound,
+
+	ldapv3.LDAPResultEntryAlreadyExists:       backend.Conflict,
+	ldapv3.LDAPResultAttributeOrValueExists:   backend.Conflict,
+	ldapv3.LDAPResultNoSuchAttribute:          backend.Conflict,
+	ldapv3.LDAPResultNotAllowedOnNonLeaf:      backend.Conflict,
+	ldapv3.LDAPResultAffectsMultipleDSAs:      backend.Conflict,
+	ldapv3.LDAPResultInvalidCredentials:       backend.Unauthorized,
+	ldapv3.LDAPResultInsufficientAccessRights: backend.Unauthorized,
+
+	ldapv3.LDAPResultInappropriateAuthentication: backend.Unauthorized,
+	ldapv3.LDAPResultStrongAuthRequired:          backend.Unauthorized,
+	ldapv3.LDAPResultConfidentialityRequired:     backend.Unauthorized,
+	ldapv3.LDAPResultAuthMethodNotSupported:      backend.Unauthorized,
+
+	ldapv3.LDAPResultBusy:               backend.RateLimited,
+	ldapv3.LDAPResultAdminLimitExceeded: backend.RateLimited,
+
+	ldapv3.LDAPResultUnavailable:       backend.Transient,
+	ldapv3.LDAPResultTimeLimitExceeded: backend.Transient,
+	ldapv3.LDAPResultTimeout:           backend.Transient,
+	ldapv3.LDAPResultServerDown:        backend.Transient,
+	ldapv3.LDAPResultConnectError:      backend.Transient,
+	ldapv3.ErrorNetwork:                backend.Transient,
+
+	// The server refusing by policy, such as a simple bind without TLS, does
+	// so until its configuration, or wellerman's, changes.
+	ldapv3.LDAPResultUnwillingToPerform: backend.Invalid,
+
+	ldapv3.LDAPResultInvalidDNSyntax:           backend.Invalid,
+	ldapv3.LDAPResultConstraintViolation:       backend.Invalid,
+	ldapv3.LDAPResultObjectClassViolation:      backend.Invalid,
+	ldapv3.LDAPResultInvalidAttributeSyntax:    backend.Invalid,
+	ldapv3.LDAPResultNamingViolation:           backend.Invalid,
+	ldapv3.LDAPResultUndefinedAttributeType:    backend.Invalid,
+	ldapv3.LDAPResultObjectClassModsProhibited: backend.Invalid,
+	ldapv3.ErrorFilterCompile:                  backend.Invalid,
+}
+
+// classify wraps the errors of go-ldap into backend Errors of the Kind of
+// their result code.
+func classify(err error) error {
+	var ldapErr *ldapv3.Error
+	if !errors.As(err, &ldapErr) {
+		return err
+	}
+
+	kind, ok := resultKinds[ldapErr.ResultCode]
+	if !ok {
+		return err
+	}
+
+	return backend.Wrap(kind, "", err)
+}
@@ -0,0 +1,31 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+)
+
+var _ = DescribeTable("classify",
+	func(err error, kind backend.Kind) {
+		classified := classify(err)
+		Expect(backend.KindOf(classified)).To(Equal(kind))
+		Expect(errors.Is(classified, err)).To(BeTrue())
+	},
+	Entry("a missing entry", ldapv3.NewError(ldapv3.LDAPResultNoSuchObject, errors.New("no such object")), backend.NotFound),
+	Entry("an existing entry", ldapv3.NewError(ldapv3.LDAPResultEntryAlreadyExists, errors.New("already exists")), backend.Conflict),
+	Entry("a member already removed", ldapv3.NewError(ldapv3.LDAPResultNoSuchAttribute, errors.New("no such attribute")), backend.Conflict),
+	Entry("refused credentials", ldapv3.NewError(ldapv3.LDAPResultInvalidCredentials, errors.New("invalid credentials")), backend.Unauthorized),
+	Entry("a busy server", ldapv3.NewError(ldapv3.LDAPResultBusy, errors.New("busy")), backend.RateLimited),
+	Entry("a network failure", ldapv3.NewError(ldapv3.ErrorNetwork, errors.New("connection reset")), backend.Transient),
+	Entry("an invalid DN", ldapv3.NewError(ldapv3.LDAPResultInvalidDNSyntax, errors.New("invalid DN")), backend.Invalid),
+	Entry("a refusal by policy", ldapv3.NewError(ldapv3.LDAPResultUnwillingToPerform, errors.New("unwilling to perform")), backend.Invalid),
+	Entry("a wrapped error", fmt.Errorf("could not modify group: %w", ldapv3.NewError(ldapv3.LDAPResultBusy, errors.New("busy"))), backend.RateLimited),
+	Entry("an unclassified result", ldapv3.NewError(ldapv3.LDAPResultOther, errors.New("other")), backend.Unknown),
+	Entry("an error not from go-ldap", errors.New("boom"), backend.Unknown),
+)
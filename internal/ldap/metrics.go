@@ -0,0 +1,33 @@
+package ldap
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	poolOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wellerman_ldap_pool_connections",
+		Help: "Number of LDAP connections currently open, by pool.",
+	}, []string{"pool"})
+
+	poolInUse = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wellerman_ldap_pool_connections_in_use",
+		Help: "Number of LDAP connections currently used by an operation, by pool.",
+	}, []string{"pool"})
+
+	poolDials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wellerman_ldap_pool_dials_total",
+		Help: "Number of LDAP connections dialled and bound, by pool and result.",
+	}, []string{"pool", "result"})
+
+	poolWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wellerman_ldap_pool_wait_seconds",
+		Help:    "Time spent waiting for a free LDAP connection, by pool.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"pool"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(poolOpen, poolInUse, poolDials, poolWaitSeconds)
+}
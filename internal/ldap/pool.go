@@ -0,0 +1,264 @@
+package ldap
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/vbouchaud/wellerman/internal/backend"
+	"github.com/vbouchaud/wellerman/internal/tracing"
+)
+
+const (
+	DefaultPoolSize        = 4
+	DefaultPoolIdleTimeout = 5 * time.Minute
+	DefaultPoolName        = "ldap"
+)
+
+// PoolOptions bounds the connections kept open to the LDAP server.
+type PoolOptions struct {
+	// Size is the maximum number of connections open at the same time.
+	Size int
+	// IdleTimeout is how long an unused connection is kept before being closed.
+	IdleTimeout time.Duration
+	// Name labels the metrics of the pool, such as directory/<name> for the
+	// pool of a Directory.
+	Name string
+}
+
+type pooledConn struct {
+	*ldapv3.Conn
+	lastUsed   time.Time
+	generation uint64
+}
+
+// pool hands out bound connections. A slot is taken for each connection in
+// use, idle connections are reused as long as they are healthy and younger
+// than idleTimeout and were dialled since the last reset. Idle connections
+// older than idleTimeout are also closed in the background until the pool is
+// closed.
+type pool struct {
+	name        string
+	dial        func(context.Context) (*ldapv3.Conn, error)
+	idleTimeout time.Duration
+	slots       chan struct{}
+	idle        chan *pooledConn
+	generation  atomic.Uint64
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newPool(dial func(context.Context) (*ldapv3.Conn, error), o PoolOptions) *pool {
+	if o.Size <= 0 {
+		o.Size = DefaultPoolSize
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = DefaultPoolIdleTimeout
+	}
+	if o.Name == "" {
+		o.Name = DefaultPoolName
+	}
+
+	p := &pool{
+		name:        o.Name,
+		dial:        dial,
+		idleTimeout: o.IdleTimeout,
+		slots:       make(chan struct{}, o.Size),
+		idle:        make(chan *pooledConn, o.Size),
+		done:        make(chan struct{}),
+	}
+	go p.reap()
+
+	return p
+}
+
+// get takes a slot, waiting for one to be free until ctx is done, and returns
+// an idle connection or, when there is none or fresh is set, a newly dialled
+// one.
+func (p *pool) get(ctx context.Context, fresh bool) (*pooledConn, error) {
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	poolWaitSeconds.WithLabelValues(p.name).Observe(time.Since(start).Seconds())
+
+	if !fresh {
+		if c := p.takeIdle(); c != nil {
+			poolInUse.WithLabelValues(p.name).Inc()
+			return c, nil
+		}
+	}
+
+	generation := p.generation.Load()
+	l, err := p.dial(ctx)
+	if err != nil {
+		poolDials.WithLabelValues(p.name, "error").Inc()
+		<-p.slots
+		return nil, err
+	}
+	poolDials.WithLabelValues(p.name, "success").Inc()
+	poolOpen.WithLabelValues(p.name).Inc()
+	poolInUse.WithLabelValues(p.name).Inc()
+
+	return &pooledConn{Conn: l, generation: generation}, nil
+}
+
+// takeIdle returns a usable idle connection, closing the unusable ones met on
+// the way, or nil when there is none.
+func (p *pool) takeIdle() *pooledConn {
+	for {
+		select {
+		case c := <-p.idle:
+			if !p.usable(c) {
+				p.discard(c)
+				continue
+			}
+			return c
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *pool) usable(c *pooledConn) bool {
+	return !c.IsClosing() && time.Since(c.lastUsed) <= p.idleTimeout && c.generation == p.generation.Load()
+}
+
+// put gives a connection back to the pool. Connections that failed at the
+// network level or that the server reported as unavailable are closed, as
+// are the ones put back once the pool is closed.
+func (p *pool) put(c *pooledConn, err error) {
+	poolInUse.WithLabelValues(p.name).Dec()
+	defer func() { <-p.slots }()
+
+	if p.closed() || c.IsClosing() || isUnavailable(err) || c.generation != p.generation.Load() {
+		p.discard(c)
+		return
+	}
+
+	c.lastUsed = time.Now()
+	select {
+	case p.idle <- c:
+	default:
+		p.discard(c)
+	}
+}
+
+func (p *pool) discard(c *pooledConn) {
+	c.Close()
+	poolOpen.WithLabelValues(p.name).Dec()
+}
+
+// reap closes the idle connections as they expire, until the pool is closed.
+func (p *pool) reap() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.expire()
+		}
+	}
+}
+
+// expire closes the idle connections that are no longer usable and keeps the
+// others.
+func (p *pool) expire() {
+	for n := len(p.idle); n > 0; n-- {
+		select {
+		case c := <-p.idle:
+			if !p.usable(c) {
+				p.discard(c)
+				continue
+			}
+			select {
+			case p.idle <- c:
+			default:
+				p.discard(c)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// reset retires the connections open so far, in use ones are closed when put
+// back.
+func (p *pool) reset() {
+	p.generation.Add(1)
+	p.drain()
+}
+
+// close stops the pool: idle connections are closed right away, in use ones
+// when put back.
+func (p *pool) close() {
+	p.closeOnce.Do(func() { close(p.done) })
+	p.drain()
+}
+
+func (p *pool) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *pool) drain() {
+	for {
+		select {
+		case c := <-p.idle:
+			p.discard(c)
+		default:
+			return
+		}
+	}
+}
+
+func isUnavailable(err error) bool {
+	return ldapv3.IsErrorAnyOf(err, ldapv3.LDAPResultUnavailable, ldapv3.LDAPResultBusy, ldapv3.ErrorNetwork)
+}
+
+// idempotent tells whether the LDAP operation op can safely be sent twice.
+// Writes cannot: one that failed at the network level may have been applied
+// before the connection broke.
+func idempotent(op string) bool {
+	return op == "search"
+}
+
+// withConn runs fn, the LDAP operation op, on a pooled connection. When the
+// connection turns out to be unusable, reads are retried once on a freshly
+// dialled one; writes are not, and are left to the next reconciliation. Both
+// attempts are traced in a single span.
+func (s *Client) withConn(ctx context.Context, op string, fn func(l *ldapv3.Conn) error) (err error) {
+	ctx, span := tracing.Start(ctx, "ldap "+op, attribute.String("ldap.url", s.ldapURL))
+	defer func() { tracing.End(span, err) }()
+
+	for attempt := 0; ; attempt++ {
+		c, err := s.pool.get(ctx, attempt > 0)
+		if err != nil {
+			return classify(err)
+		}
+
+		start := time.Now()
+		err = fn(c.Conn)
+		s.pool.put(c, err)
+		backend.Observe("ldap", op, start, classify(err))
+
+		if attempt == 0 && idempotent(op) && isUnavailable(err) {
+			continue
+		}
+
+		return classify(err)
+	}
+}
@@ -0,0 +1,224 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeServer dials connections whose server side is kept, for the tests to
+// break them as a server going away would.
+type fakeServer struct {
+	mu    sync.Mutex
+	ends  []net.Conn
+	err   error
+	dials int
+}
+
+func (f *fakeServer) dial(context.Context) (*ldapv3.Conn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.dials++
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	client, server := net.Pipe()
+	f.ends = append(f.ends, server)
+
+	l := ldapv3.NewConn(client, false)
+	l.Start()
+	return l, nil
+}
+
+func (f *fakeServer) dialled() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dials
+}
+
+// hangUp closes the server side of every connection dialled so far.
+func (f *fakeServer) hangUp() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, end := range f.ends {
+		end.Close()
+	}
+}
+
+var errNetwork = ldapv3.NewError(ldapv3.ErrorNetwork, errors.New("connection reset"))
+
+var _ = Describe("pool", func() {
+	var (
+		server *fakeServer
+		p      *pool
+	)
+
+	BeforeEach(func() {
+		server = &fakeServer{}
+		p = newPool(server.dial, PoolOptions{Size: 2, IdleTimeout: time.Minute, Name: "test"})
+		DeferCleanup(p.close)
+	})
+
+	It("reuses idle connections", func() {
+		c, err := p.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		p.put(c, nil)
+
+		again, err := p.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(BeIdenticalTo(c))
+		Expect(server.dialled()).To(Equal(1))
+	})
+
+	It("dials a new connection when asked for a fresh one", func() {
+		c, err := p.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		p.put(c, nil)
+
+		fresh, err := p.get(context.Background(), true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fresh).NotTo(BeIdenticalTo(c))
+		Expect(server.dialled()).To(Equal(2))
+	})
+
+	It("does not reuse connections closed by the server", func() {
+		c, err := p.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		p.put(c, nil)
+
+		server.hangUp()
+		Eventually(c.IsClosing).Should(BeTrue())
+
+		again, err := p.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).NotTo(BeIdenticalTo(c))
+	})
+
+	It("does not reuse connections that failed at the network level", func() {
+		c, err := p.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		p.put(c, errNetwork)
+
+		Expect(p.idle).To(BeEmpty())
+		Expect(c.IsClosing()).To(BeTrue())
+	})
+
+	It("does not reuse connections dialled before a reset", func() {
+		c, err := p.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		p.reset()
+		p.put(c, nil)
+
+		Expect(p.idle).To(BeEmpty())
+		Expect(c.IsClosing()).To(BeTrue())
+	})
+
+	It("closes idle connections once they expire", func() {
+		p.close()
+		p = newPool(server.dial, PoolOptions{Size: 2, IdleTimeout: 20 * time.Millisecond, Name: "test"})
+
+		c, err := p.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		p.put(c, nil)
+
+		Eventually(c.IsClosing).Should(BeTrue())
+		Expect(p.idle).To(BeEmpty())
+	})
+
+	It("stops waiting for a slot when the context is done", func() {
+		for i := 0; i < 2; i++ {
+			_, err := p.get(context.Background(), false)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := p.get(ctx, false)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("gives the slot back when dialling fails", func() {
+		server.err = errNetwork
+
+		for i := 0; i < 3; i++ {
+			_, err := p.get(context.Background(), false)
+			Expect(err).To(MatchError(errNetwork))
+		}
+		Expect(p.slots).To(BeEmpty())
+	})
+
+	It("closes connections put back after it is closed", func() {
+		c, err := p.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		p.close()
+		p.put(c, nil)
+
+		Expect(p.idle).To(BeEmpty())
+		Expect(c.IsClosing()).To(BeTrue())
+	})
+})
+
+var _ = Describe("withConn", func() {
+	var (
+		server *fakeServer
+		s      *Client
+		calls  int
+	)
+
+	BeforeEach(func() {
+		server = &fakeServer{}
+		s = &Client{ldapURL: "ldap://test", pool: newPool(server.dial, PoolOptions{Size: 2, Name: "test"})}
+		DeferCleanup(s.Close)
+		calls = 0
+	})
+
+	// failOnce fails with a network error on the connection first used.
+	failOnce := func(l *ldapv3.Conn) error {
+		calls++
+		if calls == 1 {
+			return errNetwork
+		}
+		return nil
+	}
+
+	It("retries a read once on a freshly dialled connection", func() {
+		// Leave an idle connection behind, which a stale connection would be.
+		c, err := s.pool.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		other, err := s.pool.get(context.Background(), false)
+		Expect(err).NotTo(HaveOccurred())
+		s.pool.put(c, nil)
+		s.pool.put(other, nil)
+
+		Expect(s.withConn(context.Background(), "search", failOnce)).To(Succeed())
+		Expect(calls).To(Equal(2))
+		Expect(server.dialled()).To(Equal(3))
+	})
+
+	It("does not retry writes", func() {
+		for _, op := range []string{"add", "modify", "modify_dn", "delete"} {
+			calls = 0
+			err := s.withConn(context.Background(), op, failOnce)
+			Expect(err).To(MatchError(errNetwork), op)
+			Expect(calls).To(Equal(1), op)
+		}
+	})
+
+	It("does not retry a read more than once", func() {
+		err := s.withConn(context.Background(), "search", func(*ldapv3.Conn) error {
+			calls++
+			return errNetwork
+		})
+		Expect(err).To(MatchError(errNetwork))
+		Expect(calls).To(Equal(2))
+	})
+})
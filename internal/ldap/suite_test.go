@@ -0,0 +1,14 @@
+package ldap
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestLdap(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "LDAP Suite")
+}
@@ -0,0 +1,95 @@
+// Package tracing sets up the OpenTelemetry traces of wellerman: a span per
+// reconciliation, and a child span per request sent to GitLab or LDAP.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/vbouchaud/wellerman/internal/version"
+)
+
+// InstrumentationName names the tracer of wellerman.
+const InstrumentationName = "github.com/vbouchaud/wellerman"
+
+// Options configures the export of the traces.
+type Options struct {
+	// Endpoint is the host:port of the OTLP gRPC collector. Traces are not
+	// recorded when it is empty.
+	Endpoint string
+	// Insecure sends the traces without TLS.
+	Insecure bool
+	// SampleRatio is the share of the reconciliations traced, from 0 to 1.
+	SampleRatio float64
+}
+
+// Setup exports the traces as configured by options, and returns the function
+// flushing them on shutdown.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+	if options.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	clientOptions := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(options.Endpoint)}
+	if options.Insecure {
+		clientOptions = append(clientOptions, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("wellerman"),
+		semconv.ServiceVersion(version.VERSION),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name as a child of the one in ctx, if any. When
+// it starts a new trace, its id is added to the logger of the returned
+// context for the log lines to be matched with the trace.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	parent := trace.SpanContextFromContext(ctx)
+
+	ctx, span := otel.Tracer(InstrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+
+	if sc := span.SpanContext(); sc.IsValid() && sc.TraceID() != parent.TraceID() {
+		ctx = log.IntoContext(ctx, log.FromContext(ctx).WithValues("trace-id", sc.TraceID().String()))
+	}
+
+	return ctx, span
+}
+
+// End ends span, recording err as its error when set.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
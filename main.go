@@ -5,14 +5,18 @@ Copyright 2023.
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -21,8 +25,10 @@ import (
 
 	"github.com/urfave/cli/v2"
 
+	"github.com/vbouchaud/wellerman/internal/credentials"
 	gitlabClient "github.com/vbouchaud/wellerman/internal/gitlab"
 	ldapClient "github.com/vbouchaud/wellerman/internal/ldap"
+	"github.com/vbouchaud/wellerman/internal/tracing"
 	"github.com/vbouchaud/wellerman/internal/version"
 
 	appv1 "github.com/vbouchaud/wellerman/api/v1"
@@ -42,6 +48,61 @@ func init() {
 	//+kubebuilder:scaffold:scheme
 }
 
+// watchCredentials returns the current value of the credentials set by the
+// flag of the given name, or by its -file and -secret variants. They are read
+// from the Secret when set, as given when the flag or its environment variable
+// is set, then from the file when it exists. The file must exist when its own
+// flag is set. The returned function hands the later values over to the
+// backend using them.
+func watchCredentials(c *cli.Context, mgr ctrl.Manager, registry *credentials.Registry, name, flag string) (string, func(func(string) error) error, error) {
+	if secret := c.String(flag + "-secret"); secret != "" {
+		namespace, secretName, ok := strings.Cut(secret, "/")
+		if !ok {
+			return "", nil, fmt.Errorf("invalid Secret %q, expected NAMESPACE/NAME", secret)
+		}
+
+		r := &controllers.CredentialsReconciler{
+			Client:   mgr.GetClient(),
+			Name:     name,
+			Secret:   types.NamespacedName{Namespace: namespace, Name: secretName},
+			Key:      c.String(flag + "-secret-key"),
+			Registry: registry,
+		}
+		initial, err := r.Load(context.Background(), mgr.GetAPIReader())
+		if err != nil {
+			return "", nil, err
+		}
+
+		return initial, func(onChange func(string) error) error {
+			r.OnChange = onChange
+			return r.SetupWithManager(mgr)
+		}, nil
+	}
+
+	file := c.String(flag + "-file")
+	if _, err := os.Stat(file); !c.IsSet(flag) && file != "" && (err == nil || c.IsSet(flag+"-file")) {
+		w := &credentials.FileWatcher{
+			Name:     name,
+			Path:     file,
+			Interval: c.Duration("credentials-poll-interval"),
+			Registry: registry,
+		}
+		initial, err := w.Load()
+		if err != nil {
+			return "", nil, err
+		}
+
+		return initial, func(onChange func(string) error) error {
+			w.OnChange = onChange
+			return mgr.Add(w)
+		}, nil
+	}
+
+	registry.Record(name, "flag", "")
+
+	return c.String(flag), func(func(string) error) error { return nil }, nil
+}
+
 func serve() *cli.Command {
 	return &cli.Command{
 		Name:     "serve",
@@ -52,10 +113,18 @@ func serve() *cli.Command {
 			// Operator default flags
 			&cli.StringFlag{
 				Name:     "metrics-bind-address",
+				Aliases:  []string{"metrics-bin-address"},
 				Category: "operator related options:",
-				Usage:    "The `ADDRESS` the metric endpoint binds to.",
+				Usage:    "The `ADDRESS` the metric endpoint binds to. --metrics-bin-address is a deprecated alias, to be removed in a later release.",
 				Value:    ":8080",
 			},
+			&cli.BoolFlag{
+				Name:     "per-resource-metrics",
+				Category: "operator related options:",
+				EnvVars:  []string{"PER_RESOURCE_METRICS"},
+				Usage:    "Report the last sync time of every Team, ClusterTeam and Project, and the members of every group, in series labelled by resource. Only aggregates by kind are reported otherwise.",
+				Value:    false,
+			},
 			&cli.StringFlag{
 				Name:     "health-probe-bind-address",
 				Category: "operator related options:",
@@ -68,6 +137,170 @@ func serve() *cli.Command {
 				Usage:    "Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.",
 				Value:    false,
 			},
+			&cli.BoolFlag{
+				Name:     "enable-webhooks",
+				Category: "operator related options:",
+				EnvVars:  []string{"ENABLE_WEBHOOKS"},
+				Usage:    "Serve the admission webhooks on port 9443. Disable when running the operator outside of the cluster.",
+				Value:    true,
+			},
+			&cli.DurationFlag{
+				Name:     "credentials-poll-interval",
+				Category: "operator related options:",
+				Usage:    "The `DURATION` between two checks of the credentials files.",
+				Value:    credentials.DefaultFilePollInterval,
+			},
+			&cli.StringFlag{
+				Name:     "mode",
+				Category: "operator related options:",
+				EnvVars:  []string{"MODE"},
+				Usage:    fmt.Sprintf("The `MODE` resources are reconciled in unless their %s annotation sets one: write changes: '%s', only report them, keeping deleted resources until they leave it: '%s', or leave resources untouched: '%s'.", appv1.ReconcileAnnotation, appv1.ReconcileEnforce, appv1.ReconcileDryRun, appv1.ReconcilePaused),
+				Value:    string(appv1.ReconcileEnforce),
+			},
+			&cli.BoolFlag{
+				Name:     "allow-hard-delete",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_ALLOW_HARD_DELETE"},
+				Usage:    "Delete the gitlab projects of paths with the Delete and DeleteAfter deletion policies. They are only archived otherwise.",
+				Value:    false,
+			},
+			&cli.DurationFlag{
+				Name:     "deletion-grace-period",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_DELETION_GRACE_PERIOD"},
+				Usage:    "The `DURATION` gitlab projects with the Delete deletion policy are kept archived before being deleted, 0 to delete them right away.",
+				Value:    7 * 24 * time.Hour,
+			},
+			&cli.StringFlag{
+				Name:     "pending-deletions-configmap",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_PENDING_DELETIONS_CONFIGMAP"},
+				Usage:    "The `NAMESPACE/NAME` of the ConfigMap recording the gitlab projects scheduled for deletion, created when missing. Only those projects are deleted once their grace period ended.",
+				Value:    "wellerman-system/wellerman-pending-deletions",
+			},
+			&cli.BoolFlag{
+				Name:     "require-team-deletion-confirmation",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_REQUIRE_TEAM_DELETION_CONFIRMATION"},
+				Usage:    fmt.Sprintf("Keep the group of a deleted Team that still has members until the Team is annotated with %s=true.", appv1.ConfirmDeletionAnnotation),
+				Value:    false,
+			},
+			&cli.DurationFlag{
+				Name:     "team-resync-period",
+				Category: "operator related options:",
+				EnvVars:  []string{"TEAM_RESYNC_PERIOD"},
+				Usage:    "The `DURATION` between two checks of the group of a Team for changes made outside of the operator, 0 to disable.",
+				Value:    time.Hour,
+			},
+			&cli.DurationFlag{
+				Name:     "project-resync-period",
+				Category: "operator related options:",
+				EnvVars:  []string{"PROJECT_RESYNC_PERIOD"},
+				Usage:    "The `DURATION` between two checks of the gitlab projects of a Project for changes made outside of the operator, 0 to disable.",
+				Value:    time.Hour,
+			},
+			&cli.Float64Flag{
+				Name:     "resync-jitter",
+				Category: "operator related options:",
+				EnvVars:  []string{"RESYNC_JITTER"},
+				Usage:    "The maximum `FACTOR` of the resync period randomly added to it.",
+				Value:    0.1,
+			},
+			&cli.StringFlag{
+				Name:     "drift-policy",
+				Category: "operator related options:",
+				EnvVars:  []string{"DRIFT_POLICY"},
+				Usage:    fmt.Sprintf("The `POLICY` applied to changes made outside of the operator: correct them: '%s', or only report them: '%s'.", controllers.DriftPolicyCorrect, controllers.DriftPolicyReport),
+				Value:    controllers.DriftPolicyCorrect,
+			},
+			&cli.IntFlag{
+				Name:     "team-max-concurrent-reconciles",
+				Category: "operator related options:",
+				EnvVars:  []string{"TEAM_MAX_CONCURRENT_RECONCILES"},
+				Usage:    "The `NUMBER` of Teams, and of ClusterTeams, reconciled at once.",
+				Value:    1,
+			},
+			&cli.IntFlag{
+				Name:     "project-max-concurrent-reconciles",
+				Category: "operator related options:",
+				EnvVars:  []string{"PROJECT_MAX_CONCURRENT_RECONCILES"},
+				Usage:    "The `NUMBER` of Projects reconciled at once.",
+				Value:    1,
+			},
+			&cli.StringFlag{
+				Name:     "otlp-endpoint",
+				Category: "operator related options:",
+				EnvVars:  []string{"OTLP_ENDPOINT"},
+				Usage:    "The `HOST:PORT` of the OTLP gRPC collector traces are exported to. Traces are not recorded when unset.",
+			},
+			&cli.BoolFlag{
+				Name:     "otlp-insecure",
+				Category: "operator related options:",
+				EnvVars:  []string{"OTLP_INSECURE"},
+				Usage:    "Export traces without TLS.",
+				Value:    false,
+			},
+			&cli.Float64Flag{
+				Name:     "trace-sample-ratio",
+				Category: "operator related options:",
+				EnvVars:  []string{"TRACE_SAMPLE_RATIO"},
+				Usage:    "The `RATIO` of reconciliations traced, from 0 to 1.",
+				Value:    1,
+			},
+			&cli.Float64Flag{
+				Name:     "gitlab-requests-per-second",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_REQUESTS_PER_SECOND"},
+				Usage:    "The `RATE` of requests sent to each gitlab instance, 0 for no limit. Requests are also held back when gitlab asks for it.",
+				Value:    0,
+			},
+			&cli.IntFlag{
+				Name:     "gitlab-burst",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_BURST"},
+				Usage:    "The `NUMBER` of requests sent to a gitlab instance at once above gitlab-requests-per-second.",
+				Value:    10,
+			},
+			&cli.DurationFlag{
+				Name:     "gitlab-path-cache-ttl",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_PATH_CACHE_TTL"},
+				Usage:    "The `DURATION` the ids of gitlab groups and projects are remembered for, 0 to look them up on every reconciliation. Projects are still read once per path and reconciliation, by id rather than by search.",
+				Value:    gitlabClient.DefaultPathCacheTTL,
+			},
+			&cli.StringFlag{
+				Name:     "gitlab-hook-bind-address",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_HOOK_BIND_ADDRESS"},
+				Usage:    "The `ADDRESS` gitlab system hooks are received on, at /hooks/gitlab, to reconcile the Projects they are about right away. Only the leader listens. Set to 0 to disable.",
+				Value:    "0",
+			},
+			&cli.StringFlag{
+				Name:     "gitlab-hook-token",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_HOOK_TOKEN"},
+				Usage:    "The secret `TOKEN` gitlab system hooks must hold. Prefer --gitlab-hook-token-file or --gitlab-hook-token-secret, which are reloaded when they change. Takes precedence over the file when set.",
+			},
+			&cli.StringFlag{
+				Name:     "gitlab-hook-token-file",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_HOOK_TOKEN_FILE"},
+				Usage:    "The `PATH` of a file holding the hook token, reloaded when it changes. The default path is used when it exists and --gitlab-hook-token is not set.",
+				Value:    "/etc/secrets/gitlab/hook-token",
+			},
+			&cli.StringFlag{
+				Name:     "gitlab-hook-token-secret",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_HOOK_TOKEN_SECRET"},
+				Usage:    "The `NAMESPACE/NAME` of a Secret holding the hook token, reloaded when it changes. Takes precedence over the other sources.",
+			},
+			&cli.StringFlag{
+				Name:     "gitlab-hook-token-secret-key",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_HOOK_TOKEN_SECRET_KEY"},
+				Usage:    "The `KEY` of the token in the hook token Secret.",
+				Value:    "hook-token",
+			},
 
 			// ldap related flags
 			&cli.StringFlag{
@@ -87,8 +320,27 @@ func serve() *cli.Command {
 				Name:     "bind-credentials",
 				Category: "ldap related options:",
 				EnvVars:  []string{"LDAP_BINDCREDENTIALS"},
-				FilePath: "/etc/secrets/ldap/password",
-				Usage:    "The service account `PASSWORD` to authenticate against the LDAP service., can be located in '/etc/secrets/ldap/password'.",
+				Usage:    "The service account `PASSWORD` to authenticate against the LDAP service. Prefer --bind-credentials-file or --bind-credentials-secret, which are reloaded when they change. Takes precedence over the file when set.",
+			},
+			&cli.StringFlag{
+				Name:     "bind-credentials-file",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_BINDCREDENTIALS_FILE"},
+				Usage:    "The `PATH` of a file holding the service account password, reloaded when it changes. The default path is used when it exists and --bind-credentials is not set.",
+				Value:    "/etc/secrets/ldap/password",
+			},
+			&cli.StringFlag{
+				Name:     "bind-credentials-secret",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_BINDCREDENTIALS_SECRET"},
+				Usage:    "The `NAMESPACE/NAME` of a Secret holding the service account password, reloaded when it changes. Takes precedence over the other sources.",
+			},
+			&cli.StringFlag{
+				Name:     "bind-credentials-secret-key",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_BINDCREDENTIALS_SECRET_KEY"},
+				Usage:    "The `KEY` of the password in the bind credentials Secret.",
+				Value:    "password",
 			},
 			&cli.StringFlag{
 				Name:     "group-search-base",
@@ -100,15 +352,15 @@ func serve() *cli.Command {
 				Name:     "group-search-scope",
 				Category: "ldap related options:",
 				EnvVars:  []string{"LDAP_GROUP_SEARCHSCOPE"},
-				Usage:    fmt.Sprintf("The `SCOPE` of the search. Can take to values base object: '%s', single level: '%s' or whole subtree: '%s'.", ldapClient.ScopeBaseObject, ldapClient.ScopeSingleLevel, ldapClient.ScopeWholeSubtree),
-				Value:    ldapClient.ScopeSingleLevel,
+				Usage:    "Deprecated and ignored, groups are looked up from their DN, see --group-dn-template. It will be removed in a later release.",
+				Hidden:   true,
 			},
 			&cli.StringFlag{
 				Name:     "group-search-filter",
 				Category: "ldap related options:",
 				EnvVars:  []string{"LDAP_GROUP_SEARCHFILTER"},
-				Usage:    "The `FILTER` to select groups.",
-				Value:    "(&(objectClass=groupOfUniqueNames)(cn=%s))",
+				Usage:    "Deprecated and ignored, groups are looked up from their DN, see --group-dn-template. It will be removed in a later release.",
+				Hidden:   true,
 			},
 			&cli.StringFlag{
 				Name:     "group-name-property",
@@ -117,6 +369,147 @@ func serve() *cli.Command {
 				Usage:    "The `PROPERTY` that contains group names.",
 				Value:    "cn",
 			},
+			&cli.StringFlag{
+				Name:     "group-dn-template",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_GROUP_DNTEMPLATE"},
+				Usage:    "The go `TEMPLATE` giving the DN of the group of a Team, from its .Name, .Namespace, .Labels and .Annotations, and from .NameProperty and .SearchBase. Values from the Team are DN escaped, and the DN must be under the group search base. Missing organizational units are created.",
+				Value:    ldapClient.DefaultGroupDNTemplate,
+			},
+			&cli.StringFlag{
+				Name:     "group-object-class",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_GROUP_OBJECTCLASS"},
+				Usage:    "The `CLASS` of the groups created by wellerman.",
+				Value:    ldapClient.DefaultGroupObjectClass,
+			},
+			&cli.StringFlag{
+				Name:     "group-member-attribute",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_GROUP_MEMBERATTRIBUTE"},
+				Usage:    "The `ATTRIBUTE` holding the members of the groups created by wellerman.",
+				Value:    ldapClient.DefaultMemberAttribute,
+			},
+			&cli.StringFlag{
+				Name:     "group-owner-attribute",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_GROUP_OWNERATTRIBUTE"},
+				Usage:    "The `ATTRIBUTE` marking the groups managed by wellerman. When empty, the description prefix is used as the marker.",
+			},
+			&cli.StringFlag{
+				Name:     "group-owner-value",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_GROUP_OWNERVALUE"},
+				Usage:    "The `VALUE` of the owner attribute on the groups managed by wellerman.",
+				Value:    "wellerman",
+			},
+			&cli.StringFlag{
+				Name:     "group-description-prefix",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_GROUP_DESCRIPTIONPREFIX"},
+				Usage:    "The `PREFIX` of the description of the groups managed by wellerman, when no owner attribute is set.",
+				Value:    ldapClient.DefaultDescriptionPrefix,
+			},
+			&cli.StringFlag{
+				Name:     "user-search-base",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_USER_SEARCHBASE"},
+				Usage:    "The `DN` where team members given by login or email are looked up.",
+			},
+			&cli.StringFlag{
+				Name:     "user-search-scope",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_USER_SEARCHSCOPE"},
+				Usage:    fmt.Sprintf("The `SCOPE` of the user search. Can take to values base object: '%s', single level: '%s' or whole subtree: '%s'.", ldapClient.ScopeBaseObject, ldapClient.ScopeSingleLevel, ldapClient.ScopeWholeSubtree),
+				Value:    ldapClient.ScopeWholeSubtree,
+			},
+			&cli.StringFlag{
+				Name:     "user-search-filter",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_USER_SEARCHFILTER"},
+				Usage:    "The `FILTER` to select a user from its login.",
+				Value:    "(&(objectClass=inetOrgPerson)(uid=%s))",
+			},
+			&cli.StringFlag{
+				Name:     "user-email-filter",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_USER_EMAILFILTER"},
+				Usage:    "The `FILTER` to select a user from its email.",
+				Value:    "(&(objectClass=inetOrgPerson)(mail=%s))",
+			},
+			&cli.StringFlag{
+				Name:     "team-naming-strategy",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_TEAM_NAMINGSTRATEGY"},
+				Usage:    fmt.Sprintf("The `STRATEGY` naming the group of a Team without an explicit groupName: after the Team only: '%s', or prefixed with its namespace: '%s'. With '%[1]s', Teams of the same name in different namespaces conflict and the first to claim the group keeps it. Groups are moved when the strategy changes.", controllers.NamingStrategyName, controllers.NamingStrategyNamespacePrefix),
+				Value:    controllers.NamingStrategyName,
+			},
+			&cli.DurationFlag{
+				Name:     "member-filter-resync-period",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_MEMBER_FILTER_RESYNC_PERIOD"},
+				Usage:    "The `DURATION` between two evaluations of the member filter of a Team.",
+				Value:    10 * time.Minute,
+			},
+			&cli.BoolFlag{
+				Name:     "ldap-start-tls",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_STARTTLS"},
+				Usage:    "Upgrade the ldap:// connection with StartTLS before binding.",
+				Value:    false,
+			},
+			&cli.StringFlag{
+				Name:     "ldap-ca-file",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_CA_FILE"},
+				Usage:    "The `PATH` to a PEM bundle used to verify the LDAP server certificate, usually mounted from a secret in '/etc/secrets/ldap/ca.crt'.",
+			},
+			&cli.StringFlag{
+				Name:     "ldap-client-cert-file",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_CLIENT_CERT_FILE"},
+				Usage:    "The `PATH` to a PEM client certificate presented to the LDAP server.",
+			},
+			&cli.StringFlag{
+				Name:     "ldap-client-key-file",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_CLIENT_KEY_FILE"},
+				Usage:    "The `PATH` to the PEM private key of the client certificate.",
+			},
+			&cli.BoolFlag{
+				Name:     "ldap-sasl-external",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_SASL_EXTERNAL"},
+				Usage:    "Authenticate with the client certificate (SASL EXTERNAL) instead of the bind DN and password.",
+				Value:    false,
+			},
+			&cli.StringFlag{
+				Name:     "ldap-server-name",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_SERVER_NAME"},
+				Usage:    "The `NAME` used to verify the LDAP server certificate, defaults to the host of --ldap-url.",
+			},
+			&cli.BoolFlag{
+				Name:     "ldap-insecure-skip-verify",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_INSECURE_SKIP_VERIFY"},
+				Usage:    "Do not verify the LDAP server certificate. Only meant for lab environments.",
+				Value:    false,
+			},
+			&cli.IntFlag{
+				Name:     "ldap-pool-size",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_POOL_SIZE"},
+				Usage:    "The maximum `NUMBER` of connections opened to the LDAP server at the same time.",
+				Value:    ldapClient.DefaultPoolSize,
+			},
+			&cli.DurationFlag{
+				Name:     "ldap-pool-idle-timeout",
+				Category: "ldap related options:",
+				EnvVars:  []string{"LDAP_POOL_IDLE_TIMEOUT"},
+				Usage:    "The `DURATION` after which an unused LDAP connection is closed.",
+				Value:    ldapClient.DefaultPoolIdleTimeout,
+			},
 
 			// gitlab related flags
 			&cli.StringFlag{
@@ -129,13 +522,50 @@ func serve() *cli.Command {
 				Name:     "gitlab-token",
 				Category: "gitlab related options:",
 				EnvVars:  []string{"GITLAB_TOKEN"},
-				Usage:    "The `TOKEN` to authenticate with.",
+				Usage:    "The `TOKEN` to authenticate with. Prefer --gitlab-token-file or --gitlab-token-secret, which are reloaded when they change. Takes precedence over the file when set.",
+			},
+			&cli.StringFlag{
+				Name:     "gitlab-token-file",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_TOKEN_FILE"},
+				Usage:    "The `PATH` of a file holding the token, reloaded when it changes. The default path is used when it exists and --gitlab-token is not set.",
+				Value:    "/etc/secrets/gitlab/token",
+			},
+			&cli.StringFlag{
+				Name:     "gitlab-token-secret",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_TOKEN_SECRET"},
+				Usage:    "The `NAMESPACE/NAME` of a Secret holding the token, reloaded when it changes. Takes precedence over the other sources.",
+			},
+			&cli.StringFlag{
+				Name:     "gitlab-token-secret-key",
+				Category: "gitlab related options:",
+				EnvVars:  []string{"GITLAB_TOKEN_SECRET_KEY"},
+				Usage:    "The `KEY` of the token in the token Secret.",
+				Value:    "token",
 			},
 		},
 		Action: func(c *cli.Context) error {
+			shutdownTracing, err := tracing.Setup(c.Context, tracing.Options{
+				Endpoint:    c.String("otlp-endpoint"),
+				Insecure:    c.Bool("otlp-insecure"),
+				SampleRatio: c.Float64("trace-sample-ratio"),
+			})
+			if err != nil {
+				setupLog.Error(err, "unable to set up tracing")
+				os.Exit(1)
+			}
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := shutdownTracing(ctx); err != nil {
+					setupLog.Error(err, "unable to flush traces")
+				}
+			}()
+
 			mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 				Scheme:                 scheme,
-				MetricsBindAddress:     c.String("metrics-bin-address"),
+				MetricsBindAddress:     c.String("metrics-bind-address"),
 				Port:                   9443,
 				HealthProbeBindAddress: c.String("health-probe-bind-address"),
 				LeaderElection:         c.Bool("leader-elect"),
@@ -157,41 +587,224 @@ func serve() *cli.Command {
 				os.Exit(1)
 			}
 
-			ldap := ldapClient.NewInstance(
+			mode := appv1.ReconcileMode(c.String("mode"))
+			if !mode.IsValid() {
+				setupLog.Error(fmt.Errorf("unknown mode %q", mode), "invalid --mode")
+				os.Exit(1)
+			}
+			setupLog.Info("Reconciling resources.", "mode", mode)
+
+			driftPolicy := c.String("drift-policy")
+			if driftPolicy != controllers.DriftPolicyCorrect && driftPolicy != controllers.DriftPolicyReport {
+				setupLog.Error(fmt.Errorf("unknown drift policy %q", driftPolicy), "invalid --drift-policy")
+				os.Exit(1)
+			}
+
+			// The group search flags are accepted for deployments still setting
+			// them to start, until they are removed.
+			for _, name := range []string{"group-search-scope", "group-search-filter"} {
+				if c.IsSet(name) {
+					setupLog.Info("Ignoring a deprecated flag, groups are placed with --group-dn-template.", "flag", name)
+				}
+			}
+
+			// Credentials in use are listed, without their value, for rotations
+			// to be confirmed.
+			registry := credentials.NewRegistry()
+			if err = mgr.AddMetricsExtraHandler("/debug/credentials", registry); err != nil {
+				setupLog.Error(err, "unable to set up credentials debug endpoint")
+				os.Exit(1)
+			}
+
+			bindPassword, watchBindPassword, err := watchCredentials(c, mgr, registry, "ldap", "bind-credentials")
+			if err != nil {
+				setupLog.Error(err, "unable to load ldap credentials")
+				os.Exit(1)
+			}
+
+			ldap, err := ldapClient.NewInstance(
 				c.String("ldap-url"),
 				c.String("bind-dn"),
-				c.String("bind-credentials"),
+				bindPassword,
 				c.String("group-search-base"),
-				c.String("group-search-scope"),
-				c.String("group-search-filter"),
 				c.String("group-name-property"),
+				c.String("group-dn-template"),
 				[]string{},
+				ldapClient.UserSearchOptions{
+					Base:        c.String("user-search-base"),
+					Scope:       c.String("user-search-scope"),
+					Filter:      c.String("user-search-filter"),
+					EmailFilter: c.String("user-email-filter"),
+				},
+				ldapClient.SchemaOptions{
+					GroupObjectClass: c.String("group-object-class"),
+					MemberAttribute:  c.String("group-member-attribute"),
+				},
+				ldapClient.OwnershipOptions{
+					Attribute:         c.String("group-owner-attribute"),
+					Value:             c.String("group-owner-value"),
+					DescriptionPrefix: c.String("group-description-prefix"),
+				},
+				ldapClient.TLSOptions{
+					StartTLS:           c.Bool("ldap-start-tls"),
+					CAFile:             c.String("ldap-ca-file"),
+					CertFile:           c.String("ldap-client-cert-file"),
+					KeyFile:            c.String("ldap-client-key-file"),
+					ServerName:         c.String("ldap-server-name"),
+					InsecureSkipVerify: c.Bool("ldap-insecure-skip-verify"),
+					SASLExternal:       c.Bool("ldap-sasl-external"),
+				},
+				ldapClient.PoolOptions{
+					Size:        c.Int("ldap-pool-size"),
+					IdleTimeout: c.Duration("ldap-pool-idle-timeout"),
+				},
 			)
-			if err = (&controllers.TeamReconciler{
-				Client: mgr.GetClient(),
-				Scheme: mgr.GetScheme(),
-				Ldap:   ldap,
-			}).SetupWithManager(mgr); err != nil {
+			if err != nil {
+				setupLog.Error(err, "unable to create ldap client")
+				os.Exit(1)
+			}
+			if err = watchBindPassword(func(password string) error {
+				ldap.SetBindPassword(password)
+				return nil
+			}); err != nil {
+				setupLog.Error(err, "unable to watch ldap credentials")
+				os.Exit(1)
+			}
+			teamReconciler := &controllers.TeamReconciler{
+				Client:                      mgr.GetClient(),
+				Scheme:                      mgr.GetScheme(),
+				Recorder:                    mgr.GetEventRecorderFor("team-controller"),
+				Ldap:                        ldap,
+				MemberFilterResyncPeriod:    c.Duration("member-filter-resync-period"),
+				NamingStrategy:              c.String("team-naming-strategy"),
+				Mode:                        mode,
+				RequireDeletionConfirmation: c.Bool("require-team-deletion-confirmation"),
+				Resync: controllers.ResyncOptions{
+					Period:      c.Duration("team-resync-period"),
+					Jitter:      c.Float64("resync-jitter"),
+					DriftPolicy: driftPolicy,
+				},
+				MaxConcurrentReconciles: c.Int("team-max-concurrent-reconciles"),
+				PerResourceMetrics:      c.Bool("per-resource-metrics"),
+				Credentials:             registry,
+				APIReader:               mgr.GetAPIReader(),
+				DirectoryDefaults: controllers.DirectoryDefaults{
+					GroupDNTemplate: c.String("group-dn-template"),
+					Ownership: ldapClient.OwnershipOptions{
+						Attribute:         c.String("group-owner-attribute"),
+						Value:             c.String("group-owner-value"),
+						DescriptionPrefix: c.String("group-description-prefix"),
+					},
+					Pool: ldapClient.PoolOptions{
+						Size:        c.Int("ldap-pool-size"),
+						IdleTimeout: c.Duration("ldap-pool-idle-timeout"),
+					},
+				},
+			}
+			if err = teamReconciler.SetupWithManager(mgr); err != nil {
 				setupLog.Error(err, "unable to create controller", "controller", "Team")
 				os.Exit(1)
 			}
+			if err = (&controllers.ClusterTeamReconciler{
+				TeamReconciler: teamReconciler,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "ClusterTeam")
+				os.Exit(1)
+			}
+
+			token, watchToken, err := watchCredentials(c, mgr, registry, "gitlab", "gitlab-token")
+			if err != nil {
+				setupLog.Error(err, "unable to load gitlab credentials")
+				os.Exit(1)
+			}
+			rateLimit := gitlabClient.RateLimitOptions{
+				RequestsPerSecond: c.Float64("gitlab-requests-per-second"),
+				Burst:             c.Int("gitlab-burst"),
+			}
+			namespace, configMap, ok := strings.Cut(c.String("pending-deletions-configmap"), "/")
+			if !ok {
+				setupLog.Error(fmt.Errorf("invalid ConfigMap %q, expected NAMESPACE/NAME", c.String("pending-deletions-configmap")), "invalid --pending-deletions-configmap")
+				os.Exit(1)
+			}
+			pendingDeletions := controllers.NewPendingDeletions(mgr.GetClient(), mgr.GetAPIReader(), types.NamespacedName{Namespace: namespace, Name: configMap})
 
 			gitlab, err := gitlabClient.NewInstance(
 				c.String("gitlab-url"),
-				c.String("gitlab-token"),
+				token,
+				gitlabClient.Options{
+					RateLimit:    rateLimit,
+					PathCacheTTL: c.Duration("gitlab-path-cache-ttl"),
+					Schedule:     pendingDeletions.For(""),
+				},
 			)
 			if err != nil {
 				setupLog.Error(err, "unable to create gitlab client")
 				os.Exit(1)
 			}
+			if err = watchToken(gitlab.SetToken); err != nil {
+				setupLog.Error(err, "unable to watch gitlab credentials")
+				os.Exit(1)
+			}
+			var hooks *controllers.HookReceiver
+			if address := c.String("gitlab-hook-bind-address"); address != "" && address != "0" {
+				hookToken, watchHookToken, err := watchCredentials(c, mgr, registry, "gitlab-hook", "gitlab-hook-token")
+				if err != nil {
+					setupLog.Error(err, "unable to load gitlab hook credentials")
+					os.Exit(1)
+				}
+				hooks = &controllers.HookReceiver{
+					Client:  mgr.GetClient(),
+					Address: address,
+					Gitlab:  gitlab,
+				}
+				if err = hooks.SetToken(hookToken); err != nil {
+					setupLog.Error(err, "unable to load gitlab hook credentials")
+					os.Exit(1)
+				}
+				if err = watchHookToken(hooks.SetToken); err != nil {
+					setupLog.Error(err, "unable to watch gitlab hook credentials")
+					os.Exit(1)
+				}
+			}
 			if err = (&controllers.ProjectReconciler{
-				Client: mgr.GetClient(),
-				Scheme: mgr.GetScheme(),
-				Gitlab: gitlab,
+				Client:              mgr.GetClient(),
+				Scheme:              mgr.GetScheme(),
+				Recorder:            mgr.GetEventRecorderFor("project-controller"),
+				Gitlab:              gitlab,
+				Credentials:         registry,
+				APIReader:           mgr.GetAPIReader(),
+				Mode:                mode,
+				AllowHardDelete:     c.Bool("allow-hard-delete"),
+				DeletionGracePeriod: c.Duration("deletion-grace-period"),
+				Resync: controllers.ResyncOptions{
+					Period:      c.Duration("project-resync-period"),
+					Jitter:      c.Float64("resync-jitter"),
+					DriftPolicy: driftPolicy,
+				},
+				MaxConcurrentReconciles: c.Int("project-max-concurrent-reconciles"),
+				PerResourceMetrics:      c.Bool("per-resource-metrics"),
+				GitlabRateLimit:         rateLimit,
+				GitlabPathCacheTTL:      c.Duration("gitlab-path-cache-ttl"),
+				Hooks:                   hooks,
+				PendingDeletions:        pendingDeletions,
 			}).SetupWithManager(mgr); err != nil {
 				setupLog.Error(err, "unable to create controller", "controller", "Project")
 				os.Exit(1)
 			}
+			if c.Bool("enable-webhooks") {
+				if err = (&appv1.Project{}).SetupWebhookWithManager(mgr); err != nil {
+					setupLog.Error(err, "unable to create webhook", "webhook", "Project")
+					os.Exit(1)
+				}
+				if err = (&appv1.Team{}).SetupWebhookWithManager(mgr); err != nil {
+					setupLog.Error(err, "unable to create webhook", "webhook", "Team")
+					os.Exit(1)
+				}
+				if err = (&appv1.ClusterTeam{}).SetupWebhookWithManager(mgr); err != nil {
+					setupLog.Error(err, "unable to create webhook", "webhook", "ClusterTeam")
+					os.Exit(1)
+				}
+			}
 			//+kubebuilder:scaffold:builder
 
 			if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {